@@ -0,0 +1,708 @@
+import collections
+import datetime
+from dataclasses import dataclass
+from typing import Optional
+
+from bson import Decimal128, ObjectId
+from pymongo.collection import Collection
+
+from errors import NoDocumentsError
+from exporters import format_value, get_path
+from text import tokenize
+
+
+# the label values beyond a cross tab's limit are folded into
+OTHER_LABEL: str = "(other)"
+
+# how many equal ranges numeric_histogram divides a field into unless told otherwise
+DEFAULT_HISTOGRAM_BINS: int = 10
+
+# what group_by can compute per group; sum and avg need a numeric field to read
+AGGREGATIONS: tuple = ("count", "sum", "avg")
+
+# units $dateTrunc accepts for bucketing a time series
+GRANULARITIES: tuple = ("minute", "hour", "day", "week", "month", "quarter", "year")
+
+
+class NoNumericValuesError(NoDocumentsError, ValueError):
+    """
+    Raised when a field holds no numeric values at all, as opposed to numeric values that are all zero.
+    """
+
+
+@dataclass
+class Stats:
+    """
+    Summary statistics over the numeric values of a field.
+
+    Attributes:
+        min (float): The smallest value.
+        max (float): The largest value.
+        avg (float): The mean value.
+        std_dev (float): The population standard deviation.
+        count (int): The number of documents with a numeric value.
+        skipped (int): The number of documents left out because the field holds something else, such as a string.
+    """
+    min: float
+    max: float
+    avg: float
+    std_dev: float
+    count: int
+    skipped: int = 0
+
+
+@dataclass
+class TimePoint:
+    """
+    The number of documents falling in one time bucket.
+
+    Attributes:
+        time (datetime.datetime): The start of the bucket, in UTC.
+        count (int): The number of documents in the bucket.
+    """
+    time: datetime.datetime
+    count: int
+
+
+@dataclass
+class Bucket:
+    """
+    One distinct value of a field and how many documents hold it.
+
+    Attributes:
+        value: The field value.
+        count (int): The number of documents with that value.
+        type (None | str): The BSON type of value as $type names it, e.g. string or int, where it was grouped by.
+    """
+    value: object
+    count: int
+    type: Optional[str] = None
+
+
+@dataclass
+class RangeBucket:
+    """
+    The number of documents whose value falls in one range of a numeric field.
+
+    Attributes:
+        lo (float): The smallest value in the range.
+        hi (float): The end of the range, exclusive except for the last range, which includes the maximum.
+        count (int): The number of documents in the range.
+    """
+    lo: float
+    hi: float
+    count: int
+
+
+@dataclass
+class Aggregation:
+    """
+    What group_by computes for each group.
+
+    Attributes:
+        op (str): One of AGGREGATIONS.
+        field (None | str): The dotted path of the numeric field sum and avg read; unused by count.
+    """
+    op: str = "count"
+    field: Optional[str] = None
+
+    def validate(self) -> None:
+        """
+        Checks the aggregation can be computed.
+
+        Raises:
+            ValueError: If op is unknown, or sum or avg has no field.
+        """
+        if self.op not in AGGREGATIONS:
+            raise ValueError(f"unknown aggregation {self.op!r}, expected one of {', '.join(AGGREGATIONS)}")
+        if self.op != "count" and not self.field:
+            raise ValueError(f"{self.op} needs a numeric field, as {self.op}:FIELD")
+
+    def accumulator(self) -> dict:
+        """
+        Builds the $group accumulator computing the aggregation.
+        """
+        if self.op == "count":
+            return {"$sum": 1}
+        return {f"${self.op}": f"${self.field}"}
+
+    @property
+    def label(self) -> str:
+        """
+        Names the aggregated value, e.g. count or avg(price).
+        """
+        return self.op if self.op == "count" else f"{self.op}({self.field})"
+
+
+def parse_aggregation(s: str) -> Aggregation:
+    """
+    Parses an aggregation given as count, sum:FIELD or avg:FIELD.
+
+    Args:
+        s (str): The aggregation.
+
+    Raises:
+        ValueError: If the aggregation is unknown or sum or avg has no field.
+    """
+    op, _, field = s.strip().partition(":")
+    agg: Aggregation = Aggregation(op=op.strip(), field=field.strip() or None)
+    agg.validate()
+    return agg
+
+
+@dataclass
+class GroupRow:
+    """
+    One combination of grouped field values and the aggregation over its documents.
+
+    Attributes:
+        key (tuple): The values of the grouped fields, in the order the fields were given.
+        value (float): The aggregated value, a count for count.
+    """
+    key: tuple
+    value: float
+
+
+@dataclass
+class CrossTab:
+    """
+    Counts of documents for each pair of values of two fields.
+
+    Attributes:
+        rows (list): The labels of field_a's values, most common first.
+        columns (list): The labels of field_b's values, most common first.
+        counts (list): One list per row holding the count for each column.
+    """
+    rows: list
+    columns: list
+    counts: list
+
+
+@dataclass
+class Completeness:
+    """
+    How many documents have a usable value for a field.
+
+    Attributes:
+        present (int): Documents where the field holds a non-null value.
+        null (int): Documents where the field is explicitly null.
+        missing (int): Documents without the field at all.
+    """
+    present: int
+    null: int
+    missing: int
+
+    @property
+    def total(self) -> int:
+        """
+        The number of documents counted.
+        """
+        return self.present + self.null + self.missing
+
+
+def value_label(value) -> str:
+    """
+    Renders a grouped value as a chart label, since values can be documents or null.
+
+    Args:
+        value: The field value.
+    """
+    return "(null)" if value is None else format_value(value)
+
+
+def bucket_labels(buckets: list) -> list:
+    """
+    Renders each bucket's value as a label, adding its type where two buckets would otherwise read the same.
+
+    A field holding "5" in some documents and 5 in others has a bucket for each,
+    labelled 5 (string) and 5 (int), so dirty data shows up instead of looking like
+    a duplicate.
+
+    Args:
+        buckets (list): Buckets, e.g. from field_histogram.
+
+    Returns:
+        list: A label per bucket, in the same order.
+    """
+    labels: list = [value_label(bucket.value) for bucket in buckets]
+    repeated: collections.Counter = collections.Counter(labels)
+    return [f"{label} ({bucket.type})" if repeated[label] > 1 and bucket.type else label
+            for label, bucket in zip(labels, buckets)]
+
+
+def _top_labels(totals: dict, limit: int) -> list:
+    """
+    Orders labels by their total count and keeps at most limit of them.
+
+    Args:
+        totals (dict): Maps each label to its total count.
+        limit (int): The most labels to keep; 0 means all.
+    """
+    labels: list = sorted(totals, key=lambda label: (-totals[label], label))
+    if limit > 0 and len(labels) > limit:
+        return labels[:limit] + [OTHER_LABEL]
+    return labels
+
+
+def cross_tab(collection: Collection, field_a: str, field_b: str, limit: int) -> CrossTab:
+    """
+    Counts the documents holding each combination of values of two fields.
+
+    Only documents with both fields are counted. Each axis keeps its limit most common
+    values, and the rest are added together under OTHER_LABEL so the table stays readable.
+
+    Args:
+        collection (Collection): The collection to aggregate over.
+        field_a (str): The dotted path of the field whose values become rows.
+        field_b (str): The dotted path of the field whose values become columns.
+        limit (int): The most distinct values kept per axis; 0 means no limit.
+
+    Returns:
+        CrossTab: The matrix of counts and the labels of each axis.
+    """
+    pipeline: list = [
+        {"$match": {field_a: {"$exists": True}, field_b: {"$exists": True}}},
+        {"$group": {"_id": {"a": f"${field_a}", "b": f"${field_b}"}, "count": {"$sum": 1}}},
+    ]
+
+    # labels rather than raw values, because values such as documents aren't hashable
+    pairs: list = [(value_label(doc["_id"].get("a")), value_label(doc["_id"].get("b")), doc["count"])
+                   for doc in collection.aggregate(pipeline)]
+
+    row_totals: dict = {}
+    column_totals: dict = {}
+    for a, b, count in pairs:
+        row_totals[a] = row_totals.get(a, 0) + count
+        column_totals[b] = column_totals.get(b, 0) + count
+
+    rows: list = _top_labels(row_totals, limit)
+    columns: list = _top_labels(column_totals, limit)
+    row_index: dict = {label: i for i, label in enumerate(rows)}
+    column_index: dict = {label: i for i, label in enumerate(columns)}
+
+    counts: list = [[0] * len(columns) for _ in rows]
+    for a, b, count in pairs:
+        i: int = row_index.get(a, len(rows) - 1)
+        j: int = column_index.get(b, len(columns) - 1)
+        counts[i][j] += count
+
+    return CrossTab(rows=rows, columns=columns, counts=counts)
+
+
+def print_cross_tab(table: CrossTab, corner: str = "") -> None:
+    """
+    Prints a CrossTab as an aligned grid with a row per field_a value.
+
+    Args:
+        table (CrossTab): The cross tab to print.
+        corner (str): Text for the top-left cell, typically the row field's name.
+    """
+    width: int = max([len(label) for label in table.rows] + [len(corner)])
+    widths: list = [max([len(label)] + [len(str(row[j])) for row in table.counts]) for j, label in enumerate(table.columns)]
+    print("  ".join([f"{corner:<{width}}"] + [f"{label:>{w}}" for label, w in zip(table.columns, widths)]))
+    for label, row in zip(table.rows, table.counts):
+        print("  ".join([f"{label:<{width}}"] + [f"{count:>{w}}" for count, w in zip(row, widths)]))
+
+
+def field_completeness(collection: Collection, fields: list) -> dict:
+    """
+    Counts, for each field, the documents where it is present, null, or missing.
+
+    Everything is counted in a single pass over the collection.
+
+    Args:
+        collection (Collection): The collection to aggregate over.
+        fields (list): The dotted paths of the fields to check.
+
+    Returns:
+        dict: Maps each field to its Completeness, in the order given.
+    """
+    group: dict = {"_id": None}
+    for i, name in enumerate(fields):
+        # $type reports "missing" for an absent field, which $eq: null can't tell apart from null
+        kind: dict = {"$type": f"${name}"}
+        # accumulator names can't contain dots, so number them instead of using the path
+        group[f"present_{i}"] = {"$sum": {"$cond": [{"$in": [kind, ["missing", "null"]]}, 0, 1]}}
+        group[f"null_{i}"] = {"$sum": {"$cond": [{"$eq": [kind, "null"]}, 1, 0]}}
+        group[f"missing_{i}"] = {"$sum": {"$cond": [{"$eq": [kind, "missing"]}, 1, 0]}}
+
+    results: list = list(collection.aggregate([{"$group": group}]))
+    doc: dict = results[0] if results else {}
+    return {name: Completeness(present=doc.get(f"present_{i}", 0), null=doc.get(f"null_{i}", 0),
+                               missing=doc.get(f"missing_{i}", 0))
+            for i, name in enumerate(fields)}
+
+
+def print_completeness(completeness: dict) -> None:
+    """
+    Prints field completeness as an aligned table.
+
+    Args:
+        completeness (dict): Maps field paths to Completeness, e.g. from field_completeness.
+    """
+    width: int = max([len(name) for name in completeness] + [len("field")])
+    print(f"{'field':<{width}}  {'present':>9}  {'null':>9}  {'missing':>9}")
+    for name, c in completeness.items():
+        print(f"{name:<{width}}  {c.present:>9}  {c.null:>9}  {c.missing:>9}")
+
+
+@dataclass
+class CardinalityEstimate:
+    """
+    An estimate of how many distinct values a field has, from a random sample.
+
+    Attributes:
+        estimate (int): The estimated number of distinct values in the whole collection.
+        observed (int): The distinct values actually seen in the sample.
+        sample_size (int): How many documents were sampled.
+        with_field (int): How many of the sampled documents held the field.
+        total (int): The collection's size, from its metadata.
+    """
+    estimate: int
+    observed: int
+    sample_size: int
+    with_field: int
+    total: int
+
+    @property
+    def exact(self) -> bool:
+        """
+        Whether the sample covered the whole collection, making the estimate a count.
+        """
+        return self.sample_size >= self.total
+
+
+def estimate_cardinality(collection: Collection, field: str, sample_size: int) -> CardinalityEstimate:
+    """
+    Estimates how many distinct values a field has without grouping the whole collection.
+
+    The sample's distinct count is scaled up with the bias-corrected Chao1 estimator:
+    many values seen only once suggest many more never sampled, while values seen
+    twice suggest the sample has found most of them. It's a rough figure, good for
+    telling a field with ten categories from one with a million, not for reporting.
+
+    Args:
+        collection (Collection): The collection to sample.
+        field (str): The dotted path of the field.
+        sample_size (int): How many documents to sample.
+
+    Returns:
+        CardinalityEstimate: The estimate along with the sample it was drawn from.
+    """
+    if sample_size <= 0:
+        raise ValueError(f"sample size must be positive, got {sample_size}")
+
+    total: int = collection.estimated_document_count()
+    sampled: int = min(sample_size, total)
+    pipeline: list = [
+        {"$sample": {"size": sample_size}},
+        {"$match": {field: {"$exists": True}}},
+        {"$group": {"_id": f"${field}", "count": {"$sum": 1}}},
+    ]
+    counts: list = [doc["count"] for doc in collection.aggregate(pipeline)]
+    with_field: int = sum(counts)
+    observed: int = len(counts)
+    if not with_field or sampled >= total:
+        return CardinalityEstimate(estimate=observed, observed=observed, sample_size=sampled, with_field=with_field,
+                                   total=total)
+
+    # only documents holding the field can add values, so scale to how many of them there likely are
+    population: float = total * with_field / sampled
+    singletons: int = sum(1 for count in counts if count == 1)
+    doubletons: int = sum(1 for count in counts if count == 2)
+    estimate: float = observed + singletons * (singletons - 1) / (2 * (doubletons + 1))
+    return CardinalityEstimate(estimate=min(max(round(estimate), observed), round(population)), observed=observed,
+                               sample_size=sampled, with_field=with_field, total=total)
+
+
+def field_histogram(collection: Collection, field: str, limit: int) -> list:
+    """
+    Counts the documents holding each distinct value of a field.
+
+    Values are grouped with their BSON type, so the string "5" and the number 5 are
+    counted apart, as are 5 and 5.0; see bucket_labels for telling them apart in a chart.
+
+    Args:
+        collection (Collection): The collection to aggregate over.
+        field (str): The dotted path of the field to group on.
+        limit (int): The maximum number of buckets to return; 0 means no limit.
+
+    Returns:
+        list: Buckets sorted by count descending, ties broken by value.
+    """
+    pipeline: list = [
+        # documents without the field would otherwise all land in a null bucket
+        {"$match": {field: {"$exists": True}}},
+        # $group already keeps "5" and 5 apart, but then they'd read the same once labelled
+        {"$group": {"_id": {"value": f"${field}", "type": {"$type": f"${field}"}}, "count": {"$sum": 1}}},
+        {"$sort": {"count": -1, "_id": 1}},
+    ]
+    if limit > 0:
+        pipeline.append({"$limit": limit})
+
+    return [Bucket(value=doc["_id"].get("value"), count=doc["count"], type=doc["_id"]["type"])
+            for doc in collection.aggregate(pipeline)]
+
+
+def group_by(collection: Collection, fields: list, agg: Aggregation = None, limit: int = 0) -> list:
+    """
+    Groups documents by the values of one or more fields and aggregates each group.
+
+    With one field and count this is field_histogram; more fields group by each
+    combination of their values, such as borough and cuisine. Documents without
+    every grouped field are left out, as are ones whose sum or avg field isn't a number.
+
+    Args:
+        collection (Collection): The collection to aggregate over.
+        fields (list): The dotted paths of the fields to group on.
+        agg (None | Aggregation): What to compute per group; None counts documents.
+        limit (int): The maximum number of rows to return; 0 means no limit.
+
+    Returns:
+        list: GroupRows by value descending, ties broken by key.
+
+    Raises:
+        ValueError: If no fields are given, or agg can't be computed.
+    """
+    agg = agg or Aggregation()
+    agg.validate()
+    if not fields:
+        raise ValueError("group_by needs at least one field")
+
+    match: dict = {field: {"$exists": True} for field in fields}
+    if agg.field is not None and agg.op != "count":
+        # $sum would count strings as 0 and $avg would skip them, so keep only numbers for both
+        match[agg.field] = {"$type": "number"}
+    pipeline: list = [
+        {"$match": match},
+        # dotted paths can't be field names in $group's _id, so the key fields are numbered
+        {"$group": {"_id": {f"k{i}": f"${field}" for i, field in enumerate(fields)}, "value": agg.accumulator()}},
+        {"$sort": {"value": -1, "_id": 1}},
+    ]
+    if limit > 0:
+        pipeline.append({"$limit": limit})
+
+    return [GroupRow(key=tuple(doc["_id"].get(f"k{i}") for i in range(len(fields))), value=doc["value"])
+            for doc in collection.aggregate(pipeline)]
+
+
+def word_frequency(collection: Collection, field: str, top: int) -> list:
+    """
+    Counts the words in a free-text field across the collection, leaving out stopwords.
+
+    Documents are streamed from a cursor, so only the word counts are held in memory.
+    An array of strings counts the words of every element.
+
+    Args:
+        collection (Collection): The collection to read.
+        field (str): The dotted path of the text field.
+        top (int): The maximum number of words to return; 0 means no limit.
+
+    Returns:
+        list: Buckets of words, most frequent first, ties broken alphabetically.
+    """
+    counts: collections.Counter = collections.Counter()
+    with collection.find({field: {"$type": "string"}}, projection={field: 1}) as cursor:
+        for doc in cursor:
+            value = get_path(doc, field)
+            for text in value if isinstance(value, list) else [value]:
+                if isinstance(text, str):
+                    counts.update(tokenize(text))
+
+    words: list = sorted(counts.items(), key=lambda item: (-item[1], item[0]))
+    if top > 0:
+        words = words[:top]
+    return [Bucket(value=word, count=count) for word, count in words]
+
+
+def _to_float(value) -> float:
+    """
+    Converts a number an aggregation returned to a float, including a Decimal128, which float() refuses.
+
+    Args:
+        value (int | float | Decimal128): The number.
+    """
+    if isinstance(value, Decimal128):
+        return float(value.to_decimal())
+    return float(value)
+
+
+def numeric_stats(collection: Collection, field: str) -> Stats:
+    """
+    Computes min, max, mean and standard deviation of a numeric field.
+
+    Documents where the field is missing or null are ignored. Ones holding some other
+    non-numeric value, such as a number stored as a string, are left out too but
+    counted in Stats.skipped, since they're likely data to clean up. Decimal values
+    are included, and the statistics over them come back as floats.
+
+    Args:
+        collection (Collection): The collection to aggregate over.
+        field (str): The dotted path of the field.
+
+    Returns:
+        Stats: The statistics over the numeric values found.
+
+    Raises:
+        NoNumericValuesError: If no document has a numeric value for the field.
+    """
+    pipeline: list = [
+        {"$match": {field: {"$type": "number"}}},
+        {"$group": {
+            "_id": None,
+            "min": {"$min": f"${field}"},
+            "max": {"$max": f"${field}"},
+            "avg": {"$avg": f"${field}"},
+            "std_dev": {"$stdDevPop": f"${field}"},
+            "count": {"$sum": 1},
+        }},
+    ]
+
+    results: list = list(collection.aggregate(pipeline))
+    skipped: int = collection.count_documents({field: {"$ne": None, "$not": {"$type": "number"}}})
+    if not results:
+        held: str = f" ({skipped} documents hold other types)" if skipped else ""
+        raise NoNumericValuesError(f"field {field!r} has no numeric values in {collection.name}{held}")
+
+    doc: dict = results[0]
+    # over decimal values $min, $max and $avg give a Decimal128
+    return Stats(min=_to_float(doc["min"]), max=_to_float(doc["max"]), avg=_to_float(doc["avg"]),
+                 std_dev=_to_float(doc["std_dev"]), count=doc["count"], skipped=skipped)
+
+
+def type_breakdown(collection: Collection, field: str) -> dict:
+    """
+    Counts the documents holding each BSON type in a field, to find dirty data before charting it.
+
+    Args:
+        collection (Collection): The collection to aggregate over.
+        field (str): The dotted path of the field.
+
+    Returns:
+        dict: Document counts by $type name, such as string, int or null, most common
+            first; documents without the field are counted as missing.
+    """
+    pipeline: list = [
+        {"$group": {"_id": {"$type": f"${field}"}, "count": {"$sum": 1}}},
+        {"$sort": {"count": -1, "_id": 1}},
+    ]
+    return {doc["_id"]: doc["count"] for doc in collection.aggregate(pipeline)}
+
+
+def numeric_histogram(collection: Collection, field: str, bins: int = DEFAULT_HISTOGRAM_BINS) -> list:
+    """
+    Counts the documents in each of bins equal ranges between a numeric field's minimum and maximum, using $bucket.
+
+    Unlike field_histogram this suits continuous values such as prices, where almost
+    every value is distinct. If every value is the same there is no range to divide,
+    so a single bucket from that value to itself holds them all.
+
+    Args:
+        collection (Collection): The collection to aggregate over.
+        field (str): The dotted path of the field.
+        bins (int): How many ranges to divide the field's values into.
+
+    Returns:
+        list: RangeBuckets from lowest to highest, including empty ones.
+
+    Raises:
+        ValueError: If bins isn't positive.
+        NoNumericValuesError: If no document has a numeric value for the field.
+    """
+    if bins <= 0:
+        raise ValueError(f"bins must be positive, got {bins}")
+
+    stats: Stats = numeric_stats(collection, field)
+    lo, hi = stats.min, stats.max
+    if lo == hi:
+        return [RangeBucket(lo=lo, hi=hi, count=stats.count)]
+
+    width: float = (hi - lo) / bins
+    # rounding can make neighbouring edges of a tiny range equal, which $bucket refuses
+    edges: list = sorted({lo + i * width for i in range(bins)} - {hi}) + [hi]
+    pipeline: list = [
+        {"$match": {field: {"$type": "number"}}},
+        # the last boundary is exclusive, so the maximum itself lands in the default bucket; values are
+        # grouped as doubles, or a decimal field's buckets would come back keyed by Decimal128, not the edges
+        {"$bucket": {"groupBy": {"$toDouble": f"${field}"}, "boundaries": edges, "default": "max",
+                     "output": {"count": {"$sum": 1}}}},
+    ]
+
+    counts: dict = {doc["_id"]: doc["count"] for doc in collection.aggregate(pipeline)}
+    buckets: list = [RangeBucket(lo=a, hi=b, count=counts.get(a, 0)) for a, b in zip(edges, edges[1:])]
+    buckets[-1].count += counts.get("max", 0)
+    return buckets
+
+
+def time_series(collection: Collection, date_field: str, granularity: str = "day") -> list:
+    """
+    Counts documents per time bucket of a date field, using $dateTrunc (MongoDB 5.0+).
+
+    Values stored as strings are converted server-side with $convert, which accepts
+    ISO 8601 / RFC 3339 forms such as 2024-01-31T12:00:00Z; numbers are read as
+    milliseconds since the epoch. Documents whose value is missing or can't be
+    converted, including dates held inside arrays, are left out.
+
+    Args:
+        collection (Collection): The collection to aggregate over.
+        date_field (str): The dotted path of the date field.
+        granularity (str): The bucket size, one of GRANULARITIES.
+
+    Returns:
+        list: TimePoints in chronological order.
+    """
+    if granularity not in GRANULARITIES:
+        raise ValueError(f"unknown granularity {granularity!r}, expected one of {', '.join(GRANULARITIES)}")
+
+    pipeline: list = [
+        {"$project": {"t": {"$convert": {"input": f"${date_field}", "to": "date", "onError": None, "onNull": None}}}},
+        {"$match": {"t": {"$type": "date"}}},
+        {"$group": {"_id": {"$dateTrunc": {"date": "$t", "unit": granularity}}, "count": {"$sum": 1}}},
+        {"$sort": {"_id": 1}},
+    ]
+
+    return [TimePoint(time=doc["_id"], count=doc["count"]) for doc in collection.aggregate(pipeline)]
+
+
+def objectid_timestamp(oid: ObjectId) -> datetime.datetime:
+    """
+    Reads the creation time encoded in the first four bytes of an ObjectId.
+
+    The time has one-second resolution and comes from the clock of whichever client or
+    server generated the id, so it is only as accurate as that clock.
+
+    Args:
+        oid (ObjectId): The id to read.
+
+    Returns:
+        datetime.datetime: The time the id was generated, in UTC.
+    """
+    return oid.generation_time
+
+
+def created_over_time(collection: Collection, granularity: str = "day") -> list:
+    """
+    Counts documents per time bucket of when their _id was generated, using $dateTrunc (MongoDB 5.0+).
+
+    This shows insertion volume for collections without a date field of their own. It
+    only works for the default ObjectId _id: documents with any other kind of _id are
+    left out, and ids generated long before the insert, or copied over from another
+    collection, put a document in the bucket of the original id.
+
+    Args:
+        collection (Collection): The collection to aggregate over.
+        granularity (str): The bucket size, one of GRANULARITIES.
+
+    Returns:
+        list: TimePoints in chronological order.
+    """
+    if granularity not in GRANULARITIES:
+        raise ValueError(f"unknown granularity {granularity!r}, expected one of {', '.join(GRANULARITIES)}")
+
+    pipeline: list = [
+        {"$match": {"_id": {"$type": "objectId"}}},
+        {"$group": {"_id": {"$dateTrunc": {"date": {"$toDate": "$_id"}, "unit": granularity}}, "count": {"$sum": 1}}},
+        {"$sort": {"_id": 1}},
+    ]
+
+    return [TimePoint(time=doc["_id"], count=doc["count"]) for doc in collection.aggregate(pipeline)]
@@ -0,0 +1,87 @@
+import math
+import statistics
+import time
+from dataclasses import dataclass
+
+
+DEFAULT_RUNS: int = 100
+
+
+@dataclass
+class BenchResult:
+    """
+    Latency figures over repeated runs of one operation.
+
+    Attributes:
+        runs (int): How many measured runs there were, not counting the warm-up.
+        documents (int): How many documents the last run returned.
+        min (float): The fastest run, in seconds.
+        median (float): The median run, in seconds.
+        p95 (float): The 95th percentile run, in seconds.
+        max (float): The slowest run, in seconds.
+        total (float): The time all measured runs took together, in seconds.
+    """
+    runs: int
+    documents: int
+    min: float
+    median: float
+    p95: float
+    max: float
+    total: float
+
+    @property
+    def throughput(self) -> float:
+        """
+        Runs completed per second.
+        """
+        return self.runs / self.total if self.total else 0.0
+
+    def summary(self) -> str:
+        """
+        Formats the result as one line of key=value pairs, with times in milliseconds, for scripts to parse.
+        """
+        return (f"runs={self.runs} docs={self.documents} min_ms={self.min * 1000:.3f} "
+                f"median_ms={self.median * 1000:.3f} p95_ms={self.p95 * 1000:.3f} max_ms={self.max * 1000:.3f} "
+                f"ops_per_sec={self.throughput:.2f}")
+
+
+def percentile(sorted_values: list, pct: float) -> float:
+    """
+    Picks a percentile of already sorted values by the nearest-rank method.
+
+    Args:
+        sorted_values (list): The values, in ascending order.
+        pct (float): The percentile, from 0 to 100.
+    """
+    rank: int = max(1, math.ceil(pct / 100 * len(sorted_values)))
+    return sorted_values[rank - 1]
+
+
+def benchmark(fn, runs: int = DEFAULT_RUNS) -> BenchResult:
+    """
+    Times an operation over repeated runs, after one warm-up run that isn't counted.
+
+    The warm-up pays for connection setup and loads the working set and query plan
+    cache, so the measured runs reflect steady-state latency.
+
+    Args:
+        fn (callable): Runs the operation once and returns how many documents it produced.
+        runs (int): How many runs to measure.
+
+    Returns:
+        BenchResult: The latency figures.
+    """
+    if runs <= 0:
+        raise ValueError(f"runs must be positive, got {runs}")
+
+    fn()
+    latencies: list = []
+    documents: int = 0
+    for _ in range(runs):
+        start: float = time.perf_counter()
+        documents = fn()
+        latencies.append(time.perf_counter() - start)
+
+    latencies.sort()
+    return BenchResult(runs=runs, documents=documents, min=latencies[0], median=statistics.median(latencies),
+                       p95=percentile(latencies, 95), max=latencies[-1], total=sum(latencies))
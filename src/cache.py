@@ -0,0 +1,143 @@
+import hashlib
+import threading
+import time
+
+from bson import json_util
+
+
+class _Call:
+    """
+    A load in progress, which requests for the same key wait on instead of querying again.
+
+    Attributes:
+        done (threading.Event): Set once the load has finished.
+        value: What the load returned.
+        error (None | BaseException): What the load raised, if it failed.
+    """
+
+    def __init__(self) -> None:
+        """
+        Constructs a new _Call that hasn't finished.
+        """
+        self.done: threading.Event = threading.Event()
+        self.value = None
+        self.error = None
+
+
+def cache_key(collection: str, query_filter: dict, projection: dict = None, sort: list = None, limit: int = 0,
+              skip: int = 0) -> tuple:
+    """
+    Builds the key a query's result is cached under.
+
+    The filter is hashed rather than kept, so a large $in list doesn't sit in memory
+    once per key. Its field order is part of the hash, as it is part of the query.
+
+    Args:
+        collection (str): The collection queried.
+        query_filter (dict): The filter.
+        projection (None | dict): The projection.
+        sort (None | list): The (field, direction) sort pairs.
+        limit (int): The limit.
+        skip (int): The skip.
+    """
+    digest: str = hashlib.sha256(json_util.dumps(query_filter).encode("utf-8")).hexdigest()
+    return (collection, digest, json_util.dumps(projection), tuple(sort or ()), limit, skip)
+
+
+class Cache:
+    """
+    Query results kept in memory for a few seconds, so identical dashboard requests share one query.
+
+    Concurrent requests for a key that isn't cached yet wait for the first one's query
+    rather than each sending their own, so a burst of refreshes can't stampede the
+    server. Keys are tuples from cache_key, whose first element is the collection.
+
+    Attributes:
+        ttl (float): Seconds a result is served from memory; 0 turns caching off.
+        hits (int): Requests answered from memory, including ones that waited on another's query.
+        misses (int): Requests that had to query.
+    """
+
+    def __init__(self, ttl: float, clock=time.monotonic) -> None:
+        """
+        Constructs a new, empty Cache.
+
+        Args:
+            ttl (float): Seconds a result is served from memory; 0 turns caching off.
+            clock (callable): Returns the current time in seconds, replaceable for tests.
+        """
+        self.ttl: float = ttl
+        self.hits: int = 0
+        self.misses: int = 0
+        self._clock = clock
+        self._lock: threading.Lock = threading.Lock()
+        self._entries: dict = {}
+        self._calls: dict = {}
+        # bumped by invalidate, so a query that started before it isn't cached after it
+        self._generation: int = 0
+
+    def load(self, key: tuple, fn) -> tuple:
+        """
+        Returns the cached result for key, or runs fn to get it.
+
+        Args:
+            key (tuple): The key, from cache_key.
+            fn (callable): Runs the query; called at most once at a time per key.
+
+        Returns:
+            tuple: The result and whether it came from memory rather than a query of this request's own.
+        """
+        if self.ttl <= 0:
+            return fn(), False
+
+        with self._lock:
+            entry = self._entries.get(key)
+            if entry is not None and entry[0] > self._clock():
+                self.hits += 1
+                return entry[1], True
+            call = self._calls.get(key)
+            leader: bool = call is None
+            if leader:
+                # expired results are only dropped here, which bounds them by the keys asked for within a ttl
+                now: float = self._clock()
+                for stale in [k for k, (expires, _) in self._entries.items() if expires <= now]:
+                    del self._entries[stale]
+                call = self._calls[key] = _Call()
+                generation: int = self._generation
+                self.misses += 1
+            else:
+                self.hits += 1
+
+        if not leader:
+            call.done.wait()
+            if call.error is not None:
+                raise call.error
+            return call.value, True
+
+        try:
+            call.value = fn()
+        except BaseException as e:
+            call.error = e
+            raise
+        finally:
+            with self._lock:
+                del self._calls[key]
+                if call.error is None and generation == self._generation:
+                    self._entries[key] = (self._clock() + self.ttl, call.value)
+            call.done.set()
+        return call.value, False
+
+    def invalidate(self, collection: str = None) -> None:
+        """
+        Drops cached results, e.g. when a change stream reports the collection changed.
+
+        Args:
+            collection (None | str): The collection whose results to drop; None drops everything.
+        """
+        with self._lock:
+            self._generation += 1
+            if collection is None:
+                self._entries.clear()
+                return
+            for key in [key for key in self._entries if key[0] == collection]:
+                del self._entries[key]
@@ -0,0 +1,88 @@
+from pymongo.collection import Collection
+from pymongo.errors import OperationFailure
+
+from logs import get_logger
+
+
+# the change events passed on to callers; others such as drop and invalidate end the stream
+WATCHED_OPERATIONS: tuple = ("insert", "update", "replace", "delete")
+
+# server error codes for a $changeStream run against a standalone mongod
+CHANGE_STREAMS_UNSUPPORTED: tuple = (40573, 40324)
+
+# how long the server waits for a change before returning an empty batch
+DEFAULT_MAX_AWAIT_MS: int = 15000
+
+
+class ChangeStreamsUnsupportedError(Exception):
+    """
+    Raised when the server can't open a change stream, e.g. a standalone server rather than a replica set.
+
+    Callers can catch this and fall back to polling.
+    """
+
+
+def open_change_stream(collection: Collection, max_await_ms: int = DEFAULT_MAX_AWAIT_MS):
+    """
+    Opens a change stream on a collection for inserts, updates, replaces and deletes.
+
+    Args:
+        collection (Collection): The collection to watch.
+        max_await_ms (int): How long each wait for new changes may block on the server.
+
+    Returns:
+        pymongo.change_stream.ChangeStream: The open stream, to be closed by the caller.
+
+    Raises:
+        ChangeStreamsUnsupportedError: If the deployment doesn't support change streams.
+    """
+    pipeline: list = [{"$match": {"operationType": {"$in": list(WATCHED_OPERATIONS)}}}]
+    try:
+        # the aggregate runs as the stream is created, so an unsupported server fails here
+        return collection.watch(pipeline, full_document="updateLookup", max_await_time_ms=max_await_ms)
+    except OperationFailure as e:
+        if e.code in CHANGE_STREAMS_UNSUPPORTED:
+            raise ChangeStreamsUnsupportedError(
+                f"change streams are not supported by this server (a replica set or sharded cluster is required): {e}") from e
+        raise
+
+
+def follow_changes(stream, fn, on_idle=None) -> None:
+    """
+    Calls a function on each change event from an open stream until the stream ends.
+
+    Iteration stops at the first exception raised by fn or on_idle, which is re-raised.
+
+    Args:
+        stream (pymongo.change_stream.ChangeStream): A stream from open_change_stream.
+        fn (callable): Called with each change event document.
+        on_idle (None | callable): Called whenever a wait for changes times out with none,
+            e.g. to send a keepalive.
+    """
+    logger = get_logger()
+    while stream.alive:
+        change = stream.try_next()
+        if change is None:
+            if on_idle is not None:
+                on_idle()
+            continue
+        logger.debug("%s on %s", change.get("operationType"), change.get("documentKey"))
+        fn(change)
+
+
+def watch(collection: Collection, fn, on_idle=None) -> None:
+    """
+    Calls a function on each insert, update, replace and delete in a collection as it happens.
+
+    This blocks until the stream ends or fn raises, and closes the stream on the way out.
+
+    Args:
+        collection (Collection): The collection to watch.
+        fn (callable): Called with each change event document.
+        on_idle (None | callable): Called whenever a wait for changes times out with none.
+
+    Raises:
+        ChangeStreamsUnsupportedError: If the deployment doesn't support change streams.
+    """
+    with open_change_stream(collection) as stream:
+        follow_changes(stream, fn, on_idle)
@@ -0,0 +1,239 @@
+import plotly.express as px
+import plotly.io as pio
+
+from analysis import bucket_labels, value_label
+
+
+# slices smaller than this percentage of the whole are merged, since slivers can't be read or labelled
+DEFAULT_PIE_THRESHOLD: float = 2.0
+OTHER_SLICE: str = "Other"
+
+
+def bar_chart(buckets: list, title: str = ""):
+    """
+    Builds a bar chart of bucket counts.
+
+    Args:
+        buckets (list): The buckets to plot, e.g. from field_histogram.
+        title (str): Display title for the visualization.
+
+    Returns:
+        plotly.graph_objects.Figure: The bar chart.
+    """
+    # plotly needs hashable, printable labels, and bucket values can be documents
+    labels: list = bucket_labels(buckets)
+    counts: list = [bucket.count for bucket in buckets]
+
+    fig = px.bar(x=labels, y=counts, title=title, labels={"x": "value", "y": "count"})
+    fig.update_xaxes(type="category")
+    return fig
+
+
+def render_bar_chart_svg(w, buckets: list, title: str = "") -> None:
+    """
+    Writes a bar chart of bucket counts as SVG.
+
+    Args:
+        w: A binary file-like object to write to.
+        buckets (list): The buckets to plot, e.g. from field_histogram.
+        title (str): Display title for the visualization.
+    """
+    pio.write_image(bar_chart(buckets, title), w, format="svg")
+
+
+def range_chart(buckets: list, title: str = ""):
+    """
+    Builds a histogram of RangeBuckets, with touching bars spanning each range.
+
+    Args:
+        buckets (list): The RangeBuckets to plot, e.g. from numeric_histogram.
+        title (str): Display title for the visualization.
+
+    Returns:
+        plotly.graph_objects.Figure: The histogram.
+    """
+    # a single bucket of one repeated value has no width, so it gets a nominal one to be visible
+    centers: list = [(bucket.lo + bucket.hi) / 2 for bucket in buckets]
+    widths: list = [(bucket.hi - bucket.lo) or 1 for bucket in buckets]
+    counts: list = [bucket.count for bucket in buckets]
+
+    fig = px.bar(x=centers, y=counts, title=title, labels={"x": "value", "y": "count"})
+    fig.update_traces(width=widths)
+    fig.update_layout(bargap=0)
+    return fig
+
+
+def render_range_chart_svg(w, buckets: list, title: str = "") -> None:
+    """
+    Writes a histogram of RangeBuckets as SVG.
+
+    Args:
+        w: A binary file-like object to write to.
+        buckets (list): The RangeBuckets to plot, e.g. from numeric_histogram.
+        title (str): Display title for the visualization.
+    """
+    pio.write_image(range_chart(buckets, title), w, format="svg")
+
+
+def pie_slices(buckets: list, threshold: float = DEFAULT_PIE_THRESHOLD, total: int = None) -> list:
+    """
+    Works out the slices of a pie chart, folding small buckets into an Other slice.
+
+    Args:
+        buckets (list): The buckets to plot, e.g. from field_histogram.
+        threshold (float): Buckets under this percentage of the total join Other.
+        total (None | int): The count the slices are shares of. If a histogram was cut
+            short with a limit, pass the full count; what the buckets don't cover goes to Other.
+
+    Returns:
+        list: (label, count) pairs, largest first, with Other last if there is one.
+    """
+    counted: int = sum(bucket.count for bucket in buckets)
+    total = counted if total is None else max(total, counted)
+
+    slices: list = []
+    other: int = total - counted
+    for bucket, label in sorted(zip(buckets, bucket_labels(buckets)), key=lambda pair: -pair[0].count):
+        if total and 100 * bucket.count / total < threshold:
+            other += bucket.count
+        else:
+            slices.append((label, bucket.count))
+    if other:
+        slices.append((OTHER_SLICE, other))
+    return slices
+
+
+def pie_chart(buckets: list, title: str = "", threshold: float = DEFAULT_PIE_THRESHOLD, total: int = None):
+    """
+    Builds a pie chart of bucket counts with a legend.
+
+    Args:
+        buckets (list): The buckets to plot, e.g. from field_histogram.
+        title (str): Display title for the visualization.
+        threshold (float): Buckets under this percentage of the total join an Other slice.
+        total (None | int): The count the slices are shares of, see pie_slices.
+
+    Returns:
+        plotly.graph_objects.Figure: The pie chart.
+    """
+    slices: list = pie_slices(buckets, threshold, total)
+    fig = px.pie(names=[label for label, _ in slices], values=[count for _, count in slices], title=title)
+    # keep Other last rather than letting plotly sort it in among the real values
+    fig.update_traces(sort=False)
+    return fig
+
+
+def render_pie_chart_svg(w, buckets: list, title: str = "", threshold: float = DEFAULT_PIE_THRESHOLD,
+                         total: int = None) -> None:
+    """
+    Writes a pie chart of bucket counts as SVG.
+
+    Args:
+        w: A binary file-like object to write to.
+        buckets (list): The buckets to plot, e.g. from field_histogram.
+        title (str): Display title for the visualization.
+        threshold (float): Buckets under this percentage of the total join an Other slice.
+        total (None | int): The count the slices are shares of, see pie_slices.
+    """
+    pio.write_image(pie_chart(buckets, title, threshold, total), w, format="svg")
+
+
+def grouped_bar_chart(rows: list, fields: list, measure: str = "count", title: str = ""):
+    """
+    Builds a bar chart of GroupRows, with one bar group per value of the first field.
+
+    With a second field its values become side-by-side bars in each group. Further
+    fields are joined into the second's label, so every combination still gets a bar.
+
+    Args:
+        rows (list): The GroupRows to plot, e.g. from group_by.
+        fields (list): The grouped fields, for the axis and legend titles.
+        measure (str): What the bars measure, e.g. avg(price).
+        title (str): Display title for the visualization.
+
+    Returns:
+        plotly.graph_objects.Figure: The bar chart.
+    """
+    groups: list = [value_label(row.key[0]) for row in rows]
+    values: list = [row.value for row in rows]
+    if len(fields) == 1:
+        fig = px.bar(x=groups, y=values, title=title, labels={"x": fields[0], "y": measure})
+    else:
+        series: list = [" / ".join(value_label(value) for value in row.key[1:]) for row in rows]
+        fig = px.bar(x=groups, y=values, color=series, barmode="group", title=title,
+                     labels={"x": fields[0], "y": measure, "color": " / ".join(fields[1:])})
+    fig.update_xaxes(type="category")
+    return fig
+
+
+def render_grouped_bar_chart_svg(w, rows: list, fields: list, measure: str = "count", title: str = "") -> None:
+    """
+    Writes a bar chart of GroupRows as SVG.
+
+    Args:
+        w: A binary file-like object to write to.
+        rows (list): The GroupRows to plot, e.g. from group_by.
+        fields (list): The grouped fields, for the axis and legend titles.
+        measure (str): What the bars measure, e.g. avg(price).
+        title (str): Display title for the visualization.
+    """
+    pio.write_image(grouped_bar_chart(rows, fields, measure, title), w, format="svg")
+
+
+def line_chart(points: list, title: str = ""):
+    """
+    Builds a line chart of counts over time.
+
+    Args:
+        points (list): The TimePoints to plot, e.g. from time_series.
+        title (str): Display title for the visualization.
+
+    Returns:
+        plotly.graph_objects.Figure: The line chart.
+    """
+    times: list = [point.time for point in points]
+    counts: list = [point.count for point in points]
+
+    return px.line(x=times, y=counts, title=title, markers=True, labels={"x": "time", "y": "count"})
+
+
+def render_line_chart_svg(w, points: list, title: str = "") -> None:
+    """
+    Writes a line chart of counts over time as SVG.
+
+    Args:
+        w: A binary file-like object to write to.
+        points (list): The TimePoints to plot, e.g. from time_series.
+        title (str): Display title for the visualization.
+    """
+    pio.write_image(line_chart(points, title), w, format="svg")
+
+
+def heatmap(table, title: str = ""):
+    """
+    Builds a heatmap of a cross tab, with darker cells for higher counts.
+
+    Args:
+        table (CrossTab): The counts to plot, e.g. from cross_tab.
+        title (str): Display title for the visualization.
+
+    Returns:
+        plotly.graph_objects.Figure: The heatmap.
+    """
+    fig = px.imshow(table.counts, x=table.columns, y=table.rows, title=title, text_auto=True,
+                    color_continuous_scale="Blues", labels={"color": "count"}, aspect="auto")
+    fig.update_xaxes(type="category")
+    fig.update_yaxes(type="category")
+    return fig
+
+
+def render_heatmap_svg(w, table, title: str = "") -> None:
+    """
+    Writes a heatmap of a cross tab as SVG.
+
+    Args:
+        w: A binary file-like object to write to.
+        table (CrossTab): The counts to plot, e.g. from cross_tab.
+        title (str): Display title for the visualization.
+    """
+    pio.write_image(heatmap(table, title), w, format="svg")
@@ -0,0 +1,119 @@
+import json
+import os
+import tempfile
+
+from logs import get_logger
+
+
+class Checkpoint:
+    """
+    Records how far an import has got through each of its sources, so an interrupted one can resume.
+
+    The file is rewritten after every batch, to a temporary file that then replaces
+    it, so a crash leaves either the old progress or the new and never half of
+    either. A crash after a batch is written but before its checkpoint means that
+    batch is sent again on resume; with -upsert-key, or documents carrying their own
+    _id, the repeat replaces or is refused as a duplicate rather than adding copies.
+
+    Offsets count the documents that reached the writer, after renames, drops and
+    skipped schema failures, so a resumed import must read the same input with the same options.
+
+    Attributes:
+        path (str): Where the checkpoint is kept.
+        collection (str): The collection the import writes to.
+    """
+
+    def __init__(self, path: str, collection: str, resume: bool = False) -> None:
+        """
+        Constructs a new Checkpoint, reading the progress already recorded if resuming.
+
+        Args:
+            path (str): Where the checkpoint is kept.
+            collection (str): The collection the import writes to.
+            resume (bool): Continue from the progress in path; without it, path must not exist yet.
+
+        Raises:
+            ValueError: If path exists and resume isn't set, can't be read or is corrupt, or records an import
+                into another collection.
+        """
+        self.path: str = path
+        self.collection: str = collection
+        self._sources: dict = {}
+        if not os.path.exists(path):
+            return
+        if not resume:
+            raise ValueError(f"checkpoint {path} exists from an earlier import; pass -resume to continue it, or delete it")
+
+        try:
+            with open(path, encoding="utf-8") as f:
+                data = json.load(f)
+        except (OSError, json.JSONDecodeError) as e:
+            raise ValueError(f"could not read checkpoint {path}: {e}") from e
+        if not isinstance(data, dict) or not isinstance(data.get("sources", {}), dict):
+            raise ValueError(f"checkpoint {path} is corrupt: expected an object with collection and sources")
+        if data.get("collection") != collection:
+            raise ValueError(f"checkpoint {path} is for an import into {data.get('collection')!r}, not {collection!r}")
+        for source, progress in data.get("sources", {}).items():
+            offset = progress.get("offset", 0) if isinstance(progress, dict) else None
+            # type() rather than isinstance(), as a bool is an int but not an offset
+            if type(offset) is not int or not isinstance(progress.get("done", False), bool):
+                raise ValueError(f"checkpoint {path} is corrupt: expected an offset and done for {source!r}, got {progress!r}")
+        self._sources = data.get("sources", {})
+
+    def offset(self, source: str) -> int:
+        """
+        How many of a source's documents were written before the import stopped; 0 if it never started.
+        """
+        return self._sources.get(source, {}).get("offset", 0)
+
+    def done(self, source: str) -> bool:
+        """
+        Whether every document of a source was written.
+        """
+        return self._sources.get(source, {}).get("done", False)
+
+    def advance(self, source: str, offset: int) -> None:
+        """
+        Records that a source's first offset documents have been written.
+
+        Args:
+            source (str): The file or stream being imported.
+            offset (int): How many of its documents have been written.
+        """
+        self._sources[source] = {"offset": offset, "done": False}
+        self.save()
+
+    def finish(self, source: str) -> None:
+        """
+        Records that a source was written in full, so resuming skips it.
+
+        Args:
+            source (str): The file or stream imported.
+        """
+        self._sources[source] = {"offset": self.offset(source), "done": True}
+        self.save()
+
+    def save(self) -> None:
+        """
+        Writes the progress to path atomically.
+        """
+        directory: str = os.path.dirname(os.path.abspath(self.path))
+        fd, tmp = tempfile.mkstemp(prefix=".checkpoint-", dir=directory)
+        try:
+            with os.fdopen(fd, "w", encoding="utf-8") as f:
+                json.dump({"collection": self.collection, "sources": self._sources}, f)
+                f.flush()
+                # the rename only helps if the new contents reached the disk before it
+                os.fsync(f.fileno())
+            os.replace(tmp, self.path)
+        except BaseException:
+            os.unlink(tmp)
+            raise
+
+    def remove(self) -> None:
+        """
+        Deletes the checkpoint once the whole import has finished, so the next one starts afresh.
+        """
+        if os.path.exists(self.path):
+            os.remove(self.path)
+            get_logger().debug("removed checkpoint %s", self.path)
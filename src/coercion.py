@@ -0,0 +1,283 @@
+import datetime
+import math
+import re
+from dataclasses import dataclass, field
+from decimal import Decimal, DecimalException
+
+from bson import Decimal128
+
+from exporters import MISSING
+from schema import Schema
+
+
+def parse_bool(value: str) -> bool:
+    """
+    Parses the usual spreadsheet spellings of a boolean.
+
+    Args:
+        value (str): The raw value.
+    """
+    lowered: str = value.strip().lower()
+    if lowered in ("true", "t", "yes", "y", "1"):
+        return True
+    if lowered in ("false", "f", "no", "n", "0"):
+        return False
+    raise ValueError(f"invalid bool {value!r}")
+
+
+# an integer as a person would write it, so "1e3", "0x10" or "1_000" aren't taken for one
+INTEGER: re.Pattern = re.compile(r"[+-]?[0-9]+")
+
+# a decimal number as a person would write it, which float() and Decimal() are looser than,
+# taking "1_000", "inf" and "nan" too
+NUMBER: re.Pattern = re.compile(r"[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?")
+
+# the range of a BSON long, the widest integer the encoder can write
+INT64_MIN: int = -2**63
+INT64_MAX: int = 2**63 - 1
+
+
+def _parse_int(value: str) -> int:
+    """
+    Parses a whole number; the BSON encoder stores it as an int or a long by its size.
+
+    Args:
+        value (str): The raw value.
+    """
+    if not INTEGER.fullmatch(value.strip()):
+        raise ValueError(f"invalid int {value!r}")
+    number: int = int(value)
+    # anything wider would make the encoder raise partway through a batch
+    if not INT64_MIN <= number <= INT64_MAX:
+        raise ValueError(f"int {value!r} is out of range for a long")
+    return number
+
+
+def _parse_double(value: str) -> float:
+    """
+    Parses a finite floating point number, so words like "nan" and "inf" stay strings.
+
+    Args:
+        value (str): The raw value.
+    """
+    if not NUMBER.fullmatch(value.strip()):
+        raise ValueError(f"invalid double {value!r}")
+    number: float = float(value)
+    # a huge exponent such as 1e999 parses as infinity
+    if not math.isfinite(number):
+        raise ValueError(f"invalid double {value!r}")
+    return number
+
+
+def _parse_decimal(value: str) -> Decimal128:
+    """
+    Parses a finite decimal number, keeping its exact digits.
+
+    Args:
+        value (str): The raw value.
+    """
+    if not NUMBER.fullmatch(value.strip()):
+        raise ValueError(f"invalid decimal {value!r}")
+    try:
+        return Decimal128(Decimal(value.strip()))
+    except DecimalException as e:
+        # more digits or a wider exponent than a decimal128 holds
+        raise ValueError(f"decimal {value!r} doesn't fit a decimal128") from e
+
+
+def _parse_date(value: str) -> datetime.datetime:
+    """
+    Parses an ISO 8601 date or date and time, taking one without an offset as UTC.
+
+    Args:
+        value (str): The raw value.
+    """
+    try:
+        parsed: datetime.datetime = datetime.datetime.fromisoformat(value.strip())
+    except ValueError as e:
+        raise ValueError(f"invalid date {value!r}") from e
+    if parsed.tzinfo is None:
+        parsed = parsed.replace(tzinfo=datetime.timezone.utc)
+    return parsed
+
+
+# maps the BSON type names a string can be coerced to the function that converts it
+COERCERS: dict = {
+    "int": _parse_int,
+    "double": _parse_double,
+    "decimal": _parse_decimal,
+    "bool": parse_bool,
+    "date": _parse_date,
+}
+
+# folds type names that take the same conversion into the one COERCERS knows them by; a
+# field holding small and large whole numbers is seen as both int and long
+TYPE_ALIASES: dict = {
+    "long": "int",
+    "integer": "int",
+    "number": "double",
+    "boolean": "bool",
+}
+
+
+def _target(types) -> str:
+    """
+    Picks the one type a field's values should be coerced to, ignoring nulls.
+
+    Args:
+        types (iterable): The type names seen or allowed for the field.
+
+    Returns:
+        str: A key of COERCERS, or "" if the field has no single type a string converts to.
+    """
+    names: set = {TYPE_ALIASES.get(name, name) for name in types} - {"null"}
+    # a field that already holds strings alongside numbers is ambiguous, so it is left alone
+    if len(names) != 1:
+        return ""
+    name: str = names.pop()
+    return name if name in COERCERS else ""
+
+
+def coercion_targets(schema: Schema) -> dict:
+    """
+    Finds the fields of a schema whose values are all one type that strings can be converted to.
+
+    Args:
+        schema (Schema): The schema, usually inferred from the collection being imported into.
+
+    Returns:
+        dict: Maps the dotted path of each such field to its key in COERCERS.
+    """
+    targets: dict = {}
+    for stats in schema.fields:
+        target: str = _target(stats.types)
+        if target:
+            targets[stats.path] = target
+    return targets
+
+
+def json_schema_targets(json_schema, prefix: str = "") -> dict:
+    """
+    Finds the type a JSON Schema gives each field it declares one for.
+
+    Both the JSON Schema type keyword and MongoDB's bsonType are read, so a $jsonSchema
+    validator copied from the collection works too. Only properties are followed, not
+    combinators like anyOf, as those don't say which type a value should be.
+
+    Args:
+        json_schema (dict | bool): The JSON Schema.
+        prefix (str): The dotted path json_schema describes, for nested properties.
+
+    Returns:
+        dict: Maps the dotted path of each typed field to its key in COERCERS, or to "" where
+            the schema declares a type strings aren't converted to, such as string itself,
+            so the caller knows to leave that field's strings alone.
+    """
+    targets: dict = {}
+    if not isinstance(json_schema, dict):
+        return targets
+    for key, prop in json_schema.get("properties", {}).items():
+        if not isinstance(prop, dict):
+            continue
+        path: str = f"{prefix}{key}"
+        declared = prop.get("bsonType", prop.get("type", ()))
+        if declared:
+            targets[path] = _target([declared] if isinstance(declared, str) else declared)
+        targets.update(json_schema_targets(prop, f"{path}."))
+    return targets
+
+
+@dataclass
+class CoercionFailure:
+    """
+    A string that looked like it should be converted but couldn't be, and was left as it was.
+
+    Attributes:
+        index (int): The position of the document in the import, starting at 0.
+        path (str): The dotted path of the field.
+        value (str): The string.
+        target (str): The type it should have been.
+    """
+    index: int
+    path: str
+    value: str
+    target: str
+
+    def __str__(self) -> str:
+        return f"document {self.index}: {self.path}: could not coerce {self.value!r} to {self.target}"
+
+
+@dataclass
+class CoercionReport:
+    """
+    What coercion changed, so it can be audited.
+
+    Attributes:
+        targets (dict): Maps each field path coerced to the type its strings became.
+        coerced (dict): Maps each field path to how many of its values were converted.
+        failed (list): A CoercionFailure for each string that couldn't be converted.
+    """
+    targets: dict = field(default_factory=dict)
+    coerced: dict = field(default_factory=dict)
+    failed: list = field(default_factory=list)
+
+    @property
+    def total(self) -> int:
+        """
+        Values converted, across every field.
+        """
+        return sum(self.coerced.values())
+
+    def lines(self) -> list:
+        """
+        Describes each field that was coerced or failed to be, e.g. "zip: 980 coerced to int, 20 left as strings".
+        """
+        failed: dict = {}
+        for failure in self.failed:
+            failed[failure.path] = failed.get(failure.path, 0) + 1
+        lines: list = []
+        for path in sorted(set(self.coerced) | set(failed)):
+            line: str = f"{path}: {self.coerced.get(path, 0)} coerced to {self.targets[path]}"
+            if failed.get(path):
+                line += f", {failed[path]} left as strings"
+            lines.append(line)
+        return lines
+
+
+def coerce_document(doc: dict, targets: dict, report: CoercionReport, index: int = 0) -> dict:
+    """
+    Converts the strings in a document to the types their fields should have, where that's unambiguous.
+
+    Only strings are converted, and only at paths through subdocuments, not arrays.
+    Blank strings are left alone, as an empty cell is missing data rather than a
+    failed conversion. A string that doesn't parse as its target stays a string and
+    is recorded in the report.
+
+    Args:
+        doc (dict): The document, which is changed in place.
+        targets (dict): Maps dotted paths to keys of COERCERS, as from coercion_targets.
+        report (CoercionReport): Receives the count of values converted and the failures.
+        index (int): The document's position in the import, for the failures.
+
+    Returns:
+        dict: doc, changed.
+    """
+    for path, target in targets.items():
+        *parents, last = path.split(".")
+        parent = doc
+        for key in parents:
+            parent = parent.get(key)
+            if not isinstance(parent, dict):
+                break
+        else:
+            value = parent.get(last, MISSING)
+            if not isinstance(value, str) or not value.strip():
+                continue
+            report.targets[path] = target
+            try:
+                parent[last] = COERCERS[target](value)
+            except ValueError:
+                report.failed.append(CoercionFailure(index=index, path=path, value=value, target=target))
+                continue
+            report.coerced[path] = report.coerced.get(path, 0) + 1
+    return doc
@@ -0,0 +1,211 @@
+import datetime
+from dataclasses import dataclass, field
+
+from bson import Binary, Decimal128, ObjectId, Regex, Timestamp, json_util
+from pymongo.collection import Collection
+
+from exporters import format_value, get_path
+from schema import bson_type
+
+
+# how many keys of each kind a DiffResult keeps to show
+DEFAULT_DIFF_SAMPLE: int = 10
+
+
+@dataclass
+class DiffResult:
+    """
+    How two collections differ, matching documents by a key field.
+
+    Attributes:
+        only_in_a (int): Documents whose key appears only in the first collection.
+        only_in_b (int): Documents whose key appears only in the second collection.
+        changed (int): Documents present in both whose contents differ.
+        same (int): Documents present in both with identical contents.
+        sample_only_in_a (list): Up to the sample size of the keys counted in only_in_a.
+        sample_only_in_b (list): Up to the sample size of the keys counted in only_in_b.
+        sample_changed (list): Up to the sample size of the keys counted in changed.
+    """
+    only_in_a: int = 0
+    only_in_b: int = 0
+    changed: int = 0
+    same: int = 0
+    sample_only_in_a: list = field(default_factory=list)
+    sample_only_in_b: list = field(default_factory=list)
+    sample_changed: list = field(default_factory=list)
+
+    @property
+    def identical(self) -> bool:
+        """
+        Whether the collections hold exactly the same documents.
+        """
+        return not (self.only_in_a or self.only_in_b or self.changed)
+
+
+def bson_sort_key(value) -> tuple:
+    """
+    Orders key values the way the server sorts them, so a merge-join can follow two sorted cursors.
+
+    Types rank as in MongoDB's comparison order: null, numbers, strings, documents,
+    arrays, binary, ObjectIds, booleans, dates, timestamps, then regular expressions.
+
+    Args:
+        value: A value decoded from a MongoDB document.
+    """
+    if value is None:
+        return (0,)
+    # bool is an int, so it has to be checked before numbers
+    if isinstance(value, bool):
+        return (7, value)
+    if isinstance(value, (int, float)):
+        return (1, value)
+    if isinstance(value, Decimal128):
+        return (1, value.to_decimal())
+    if isinstance(value, str):
+        return (2, value)
+    if isinstance(value, dict):
+        return (3, json_util.dumps(value))
+    if isinstance(value, (list, tuple)):
+        return (4, json_util.dumps(value))
+    if isinstance(value, (bytes, Binary)):
+        return (5, bytes(value))
+    if isinstance(value, ObjectId):
+        return (6, value.binary)
+    if isinstance(value, datetime.datetime):
+        return (8, value)
+    if isinstance(value, Timestamp):
+        return (9, value.time, value.inc)
+    if isinstance(value, Regex):
+        return (10, value.pattern)
+    return (11, json_util.dumps(value))
+
+
+def comparable(value):
+    """
+    Tags a value and everything nested in it with its BSON type, so comparing the results tells types apart.
+
+    Python's == treats True as 1 and 1 as 1.0, so without this a migration turning a
+    bool into an int or an int into a double would compare the same. Documents still
+    compare with field order ignored, and arrays in order.
+
+    Args:
+        value: A value decoded from a MongoDB document.
+    """
+    if isinstance(value, dict):
+        return "object", {key: comparable(child) for key, child in value.items()}
+    if isinstance(value, (list, tuple)):
+        return "array", [comparable(element) for element in value]
+    return bson_type(value), value
+
+
+def sorted_by_key(cursor, key_field: str, name: str):
+    """
+    Yields (sort key, key value, document) from a cursor sorted on key_field, checking the order holds.
+
+    The merge-join silently misreports if the server's order and bson_sort_key ever
+    disagree, e.g. for array keys, so a key going backwards is an error instead.
+
+    Args:
+        cursor: A cursor sorted ascending on key_field.
+        key_field (str): The dotted path of the key.
+        name (str): The collection's name, for the error message.
+
+    Raises:
+        ValueError: If the keys aren't in ascending order.
+    """
+    previous = None
+    for doc in cursor:
+        value = get_path(doc, key_field)
+        key: tuple = bson_sort_key(value)
+        if previous is not None and key < previous:
+            raise ValueError(f"{name} returned {key_field} {format_value(value)} out of order; "
+                             f"array keys can't be diffed")
+        previous = key
+        yield key, value, doc
+
+
+def diff_collections(a: Collection, b: Collection, key_field: str = "_id",
+                     sample_size: int = DEFAULT_DIFF_SAMPLE) -> DiffResult:
+    """
+    Compares two collections document by document, such as a source and the copy a migration made.
+
+    Both collections are read in key order and merge-joined, so only one document
+    from each is held at a time however large they are. Documents without the key
+    field are left out. Contents are compared with field order ignored but BSON types
+    kept apart, so 1 and 1.0 differ; the driver decodes both int and long as int,
+    though, so a change between those goes unnoticed unless the value outgrows an int.
+    Matching on a key_field other than _id ignores _id, as a copy usually gets new ones.
+
+    Args:
+        a (Collection): The first collection, e.g. the migration's source.
+        b (Collection): The second collection, e.g. the migration's target.
+        key_field (str): The dotted path of the field identifying a document in both.
+        sample_size (int): How many keys of each kind of difference to keep.
+
+    Returns:
+        DiffResult: The counts of each kind of difference, with sample keys.
+
+    Raises:
+        ValueError: If either collection's keys can't be followed in order.
+    """
+    result: DiffResult = DiffResult()
+
+    def record(count_attr: str, sample: list, value) -> None:
+        setattr(result, count_attr, getattr(result, count_attr) + 1)
+        if len(sample) < sample_size:
+            sample.append(value)
+
+    def contents(doc: dict):
+        if key_field != "_id":
+            doc = {key: value for key, value in doc.items() if key != "_id"}
+        return comparable(doc)
+
+    query: dict = {key_field: {"$exists": True}}
+    # an unindexed key can need more than the in-memory sort limit
+    with a.find(query, sort=[(key_field, 1)], allow_disk_use=True) as cursor_a, \
+            b.find(query, sort=[(key_field, 1)], allow_disk_use=True) as cursor_b:
+        rows_a = sorted_by_key(cursor_a, key_field, a.name)
+        rows_b = sorted_by_key(cursor_b, key_field, b.name)
+        row_a = next(rows_a, None)
+        row_b = next(rows_b, None)
+
+        while row_a is not None or row_b is not None:
+            if row_b is None or (row_a is not None and row_a[0] < row_b[0]):
+                record("only_in_a", result.sample_only_in_a, row_a[1])
+                row_a = next(rows_a, None)
+            elif row_a is None or row_b[0] < row_a[0]:
+                record("only_in_b", result.sample_only_in_b, row_b[1])
+                row_b = next(rows_b, None)
+            else:
+                if contents(row_a[2]) == contents(row_b[2]):
+                    result.same += 1
+                else:
+                    record("changed", result.sample_changed, row_a[1])
+                row_a = next(rows_a, None)
+                row_b = next(rows_b, None)
+
+    return result
+
+
+def print_diff(result: DiffResult, name_a: str, name_b: str) -> None:
+    """
+    Prints the counts of a DiffResult and the sample keys under each.
+
+    Args:
+        result (DiffResult): The comparison to print.
+        name_a (str): What to call the first collection.
+        name_b (str): What to call the second collection.
+    """
+    rows: list = [
+        (f"only in {name_a}", result.only_in_a, result.sample_only_in_a),
+        (f"only in {name_b}", result.only_in_b, result.sample_only_in_b),
+        ("changed", result.changed, result.sample_changed),
+        ("same", result.same, []),
+    ]
+    width: int = max(len(label) for label, _, _ in rows)
+    for label, count, sample in rows:
+        print(f"{label:<{width}}  {count}")
+        for value in sample:
+            print(f"  {format_value(value)}")
+        if count > len(sample) > 0:
+            print(f"  ... and {count - len(sample)} more")
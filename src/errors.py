@@ -0,0 +1,89 @@
+import contextlib
+from typing import Optional
+
+import pymongo
+
+
+# server error codes for a filter the query parser refused, e.g. an unknown $ operator
+BAD_VALUE: int = 2
+FAILED_TO_PARSE: int = 9
+
+# the server error code for a failed authentication handshake
+AUTHENTICATION_FAILED: int = 18
+
+# the server error code for a command naming a collection that doesn't exist
+NAMESPACE_NOT_FOUND: int = 26
+
+
+class MongoVizError(Exception):
+    """
+    The base of the errors this package raises for failures a caller may want to handle, as opposed to bugs.
+
+    Driver errors that fit one of the subclasses are translated into it, with the
+    driver's error kept as __cause__; the rest propagate as PyMongoError.
+    """
+
+
+class NoDocumentsError(MongoVizError, LookupError):
+    """
+    Raised when an operation needing at least one document found none.
+    """
+
+
+class CollectionNotFoundError(MongoVizError, LookupError):
+    """
+    Raised when an operation names a collection that doesn't exist.
+
+    Most reads of a missing collection just find nothing, so this is only raised where
+    that would silently give a wrong answer, such as a $lookup from it.
+    """
+
+
+class InvalidFilterError(MongoVizError, ValueError):
+    """
+    Raised when a query filter can't be parsed, either as JSON or by the server.
+    """
+
+
+class AuthFailedError(MongoVizError):
+    """
+    Raised when the server rejects the supplied credentials.
+    """
+
+
+def translate_error(error: pymongo.errors.OperationFailure, collection: str) -> Optional[MongoVizError]:
+    """
+    Finds the package error a server error stands for.
+
+    Args:
+        error (pymongo.errors.OperationFailure): The error the server returned.
+        collection (str): The collection the operation ran on, for the message.
+
+    Returns:
+        None | MongoVizError: The error to raise instead, or None if error has no counterpart.
+    """
+    message: str = error.details.get("errmsg", str(error)) if error.details else str(error)
+    if error.code in (BAD_VALUE, FAILED_TO_PARSE):
+        return InvalidFilterError(f"invalid filter on {collection}: {message}")
+    if error.code == NAMESPACE_NOT_FOUND:
+        return CollectionNotFoundError(f"no such collection {collection}: {message}")
+    if error.code == AUTHENTICATION_FAILED:
+        return AuthFailedError(f"authentication failed: {message}")
+    return None
+
+
+@contextlib.contextmanager
+def translated_errors(collection: str):
+    """
+    Re-raises server errors inside the block as their MongoVizError counterparts, where they have one.
+
+    Args:
+        collection (str): The collection the block operates on, for the messages.
+    """
+    try:
+        yield
+    except pymongo.errors.OperationFailure as e:
+        translated: Optional[MongoVizError] = translate_error(e, collection)
+        if translated is None:
+            raise
+        raise translated from e
@@ -0,0 +1,453 @@
+import csv
+import datetime
+import itertools
+import os
+import re
+from typing import Optional
+
+from bson import Decimal128, ObjectId, json_util
+
+from logs import get_logger
+from schema import Schema
+
+
+# how many documents to look at when working out CSV columns that weren't given
+HEADER_SAMPLE_SIZE: int = 100
+
+# how many levels of subdocuments and arrays become columns of their own before the rest is kept as JSON
+DEFAULT_FLATTEN_DEPTH: int = 3
+
+ELLIPSIS: str = "\u2026"
+
+# ANSI escapes for the table header
+BOLD: str = "\033[1m"
+RESET: str = "\033[0m"
+
+CONTROL_WHITESPACE = re.compile(r"[\r\n\t]")
+
+# sentinel for a path that doesn't exist, distinct from a field explicitly set to null
+MISSING = object()
+
+
+def get_path(doc: dict, path: str):
+    """
+    Looks up a dotted path such as address.city or grades.0.score in a document.
+
+    Args:
+        doc (dict): The document to read from.
+        path (str): The dotted path; numeric segments index into arrays.
+
+    Returns:
+        The value at the path, or MISSING if any segment is absent.
+    """
+    value = doc
+    for key in path.split("."):
+        if isinstance(value, dict):
+            if key not in value:
+                return MISSING
+            value = value[key]
+        elif isinstance(value, (list, tuple)) and key.isdigit() and int(key) < len(value):
+            value = value[int(key)]
+        else:
+            return MISSING
+    return value
+
+
+def flatten_document(doc: dict, max_depth: int = DEFAULT_FLATTEN_DEPTH, arrays: bool = True) -> dict:
+    """
+    Flattens subdocuments into dotted keys such as address.city, and arrays into indexed keys such as tags.0.
+
+    The keys are the same dotted paths get_path reads, so {"a": {"b": [1, 2]}} becomes
+    {"a.b.0": 1, "a.b.1": 2}. Empty subdocuments and arrays are kept as values, so
+    their field still shows up.
+
+    Args:
+        doc (dict): The document to flatten.
+        max_depth (int): How many levels of nesting to flatten; anything deeper stays
+            a nested value. 0 returns the top-level fields unchanged.
+        arrays (bool): Flatten arrays into indexed keys too, rather than keeping them as values.
+
+    Returns:
+        dict: The flattened fields, in document order.
+    """
+    flat: dict = {}
+
+    def visit(prefix: str, value, depth: int) -> None:
+        if isinstance(value, dict):
+            items = value.items()
+        elif isinstance(value, (list, tuple)) and arrays:
+            items = ((str(i), item) for i, item in enumerate(value))
+        else:
+            items = None
+        if items is None or not value or depth >= max_depth:
+            flat[prefix] = value
+            return
+        for key, item in items:
+            visit(f"{prefix}.{key}", item, depth + 1)
+
+    for key, value in doc.items():
+        visit(key, value, 0)
+    return flat
+
+
+def get_flat(flat: dict, key: str):
+    """
+    Looks up a key of a flattened document, whose keys hold dots themselves and so aren't paths to split.
+
+    Args:
+        flat (dict): A document from flatten_document.
+        key (str): The flattened key.
+
+    Returns:
+        The value, or MISSING if the document has no such key.
+    """
+    return flat.get(key, MISSING)
+
+
+def format_value(value) -> str:
+    """
+    Renders a document value as text for a single table or CSV cell.
+
+    Args:
+        value: The value to render.
+    """
+    if value is MISSING or value is None:
+        return ""
+    if isinstance(value, str):
+        return value
+    if isinstance(value, bool):
+        return "true" if value else "false"
+    if isinstance(value, datetime.datetime):
+        return value.isoformat()
+    if isinstance(value, (dict, list, tuple)):
+        # nested values don't fit in one cell, so keep them as relaxed Extended JSON
+        return json_util.dumps(value)
+    return str(value)
+
+
+def export_csv(w, cursor, fields: list = None, max_depth: int = DEFAULT_FLATTEN_DEPTH) -> None:
+    """
+    Writes documents from a cursor as CSV, with a header row of field names.
+
+    Args:
+        w: A text file-like object to write to, opened with newline="".
+        cursor (iterable): The documents to write, typically a pymongo Cursor.
+        fields (None | list): Dotted paths to write as columns. If None, the documents
+            are flattened to max_depth and the union of keys in the first 100 is used,
+            in order of first appearance.
+        max_depth (int): How many levels of nesting become columns when fields is None.
+    """
+    documents = iter(cursor)
+
+    if fields is None:
+        documents = (flatten_document(doc, max_depth) for doc in documents)
+        # buffer the head of the cursor to find the columns, then replay it before the rest
+        head: list = list(itertools.islice(documents, HEADER_SAMPLE_SIZE))
+        fields = list(dict.fromkeys(key for doc in head for key in doc))
+        documents = itertools.chain(head, documents)
+        lookup = get_flat
+    else:
+        lookup = get_path
+
+    writer = csv.writer(w)
+    writer.writerow(fields)
+    for doc in documents:
+        writer.writerow([format_value(lookup(doc, field)) for field in fields])
+
+
+def export_jsonl(w, docs) -> None:
+    """
+    Writes documents as newline-delimited JSON, one compact object per line.
+
+    Values are encoded as relaxed Extended JSON, so ObjectIds, dates and other BSON
+    types survive a round trip through tools such as mongoimport.
+
+    Args:
+        w: A text file-like object to write to.
+        docs (iterable): The documents to write.
+    """
+    for doc in docs:
+        w.write(json_util.dumps(doc, separators=(",", ":")))
+        w.write("\n")
+
+
+def export_extended_json(w, docs, canonical: bool = False) -> None:
+    """
+    Writes documents as an indented JSON array of Extended JSON objects.
+
+    Relaxed mode keeps numbers and dates readable, e.g. {"$date": "2024-01-02T00:00:00Z"}.
+    Canonical mode wraps every number in its BSON type, e.g. {"$numberLong": "42"}, so
+    ints, longs and doubles come back exactly as they went out.
+
+    Args:
+        w: A text file-like object to write to.
+        docs (iterable): The documents to write.
+        canonical (bool): Whether to use canonical rather than relaxed Extended JSON.
+    """
+    options = json_util.CANONICAL_JSON_OPTIONS if canonical else json_util.RELAXED_JSON_OPTIONS
+    separator: str = "[\n"
+    for doc in docs:
+        w.write(separator)
+        w.write(json_util.dumps(doc, json_options=options, indent=2))
+        separator = ",\n"
+    # an empty result is still a valid array
+    w.write("[]\n" if separator == "[\n" else "\n]\n")
+
+
+# documents per Parquet row group, which bounds the memory a write needs however many there are
+PARQUET_ROW_GROUP_SIZE: int = 10000
+
+# BSON types written as Parquet strings, ObjectIds as hex and decimals as their digits so no precision is lost
+STRING_TYPES: frozenset = frozenset({"string", "javascript", "objectId", "decimal"})
+
+
+def parquet_column_type(types: set) -> str:
+    """
+    Picks the Parquet column type for the BSON types seen in a field.
+
+    Ints and longs widen to int64 and any mix of numbers to double. Anything with no
+    single column type of its own, such as arrays, subdocuments or a field holding both
+    strings and numbers, is written as a string of relaxed Extended JSON.
+
+    Args:
+        types (set): BSON type names from a FieldStats.
+
+    Returns:
+        str: One of int32, int64, double, bool, string, timestamp, binary or json.
+    """
+    types = set(types) - {"null"}
+    if not types:
+        return "string"
+    if types == {"int"}:
+        return "int32"
+    if types <= {"int", "long"}:
+        return "int64"
+    if types <= {"int", "long", "double"}:
+        return "double"
+    if types == {"bool"}:
+        return "bool"
+    if types <= STRING_TYPES:
+        return "string"
+    if types == {"date"}:
+        return "timestamp"
+    if types == {"binData"}:
+        return "binary"
+    return "json"
+
+
+def parquet_columns(schema: Schema, max_depth: int = DEFAULT_FLATTEN_DEPTH) -> list:
+    """
+    Works out the Parquet columns for documents matching a schema, flattened as flatten_document does.
+
+    Subdocuments down to max_depth become a column per field, e.g. address.city;
+    deeper ones, and arrays, stay whole in a json column of their own. Fields only
+    reached through an array are therefore part of the array's column.
+
+    Args:
+        schema (Schema): The schema, from infer_schema or schema_from_documents.
+        max_depth (int): How many levels of subdocuments become columns.
+
+    Returns:
+        list: (path, type) pairs sorted by path, with types from parquet_column_type.
+    """
+    by_path: dict = {stats.path: stats for stats in schema.fields}
+    parents: set = {path.rpartition(".")[0] for path in by_path if "." in path}
+    columns: list = []
+    for path in sorted(by_path):
+        parts: list = path.split(".")
+        depth: int = len(parts) - 1
+        if depth > max_depth:
+            continue
+        ancestors: list = [".".join(parts[:i]) for i in range(1, len(parts))]
+        if any("array" in by_path[a].types for a in ancestors if a in by_path):
+            continue
+        types: set = by_path[path].types
+        if path in parents and depth < max_depth:
+            # a subdocument is split into its fields' columns; only other values it sometimes holds need one itself
+            types = types - {"object"}
+            if not types - {"null"}:
+                continue
+        columns.append((path, parquet_column_type(types)))
+    return columns
+
+
+def parquet_value(column_type: str, value):
+    """
+    Converts a flattened value for a column of the given type.
+
+    Args:
+        column_type (str): The column's type, from parquet_column_type.
+        value: The value, which may not match the type if the schema was inferred from a sample.
+
+    Returns:
+        The value to write, or MISSING if it doesn't fit the column.
+    """
+    if value is None or column_type == "json":
+        return value if value is None else json_util.dumps(value)
+    is_int: bool = isinstance(value, int) and not isinstance(value, bool)
+    if column_type == "int32":
+        return value if is_int and -2**31 <= value < 2**31 else MISSING
+    if column_type == "int64":
+        return value if is_int and -2**63 <= value < 2**63 else MISSING
+    if column_type == "double":
+        return float(value) if is_int or isinstance(value, float) else MISSING
+    if column_type == "bool":
+        return value if isinstance(value, bool) else MISSING
+    if column_type == "string":
+        return str(value) if isinstance(value, (str, ObjectId, Decimal128)) else MISSING
+    if column_type == "timestamp":
+        return value if isinstance(value, datetime.datetime) else MISSING
+    if column_type == "binary":
+        return bytes(value) if isinstance(value, bytes) else MISSING
+    return MISSING
+
+
+def export_parquet(w, docs, schema: Schema, max_depth: int = DEFAULT_FLATTEN_DEPTH) -> int:
+    """
+    Writes documents as a Parquet file, with columns from a schema inferred beforehand.
+
+    Parquet needs every column before the first row, so the schema has to come first:
+    from infer_schema, or from schema_from_documents over a head of docs. Documents
+    are flattened as flatten_document does, see parquet_columns, and written in row
+    groups so only one group is held in memory. A field a document lacks is null.
+
+    Fields the schema didn't see, and values of a type their column can't hold, are
+    left out and counted in a warning; sampling more documents for the schema avoids them.
+
+    Args:
+        w: A binary file-like object to write to.
+        docs (iterable): The documents to write.
+        schema (Schema): The schema the columns come from.
+        max_depth (int): How many levels of subdocuments become columns.
+
+    Returns:
+        int: The number of documents written.
+
+    Raises:
+        ValueError: If pyarrow isn't installed, or the schema has no fields.
+    """
+    try:
+        import pyarrow as pa
+        import pyarrow.parquet as pq
+    except ImportError as e:
+        raise ValueError("writing Parquet needs the pyarrow package (pip install pyarrow)") from e
+
+    columns: list = parquet_columns(schema, max_depth)
+    if not columns:
+        raise ValueError("the schema has no fields to make Parquet columns from")
+    arrow_types: dict = {
+        "int32": pa.int32(), "int64": pa.int64(), "double": pa.float64(), "bool": pa.bool_(), "string": pa.string(),
+        "timestamp": pa.timestamp("ms", tz="UTC"), "binary": pa.binary(), "json": pa.string(),
+    }
+    arrow_schema = pa.schema([pa.field(path, arrow_types[column_type]) for path, column_type in columns])
+    known: set = {path for path, _ in columns}
+    # subdocuments split into columns, which a document may still hold as null or some other value
+    split: set = {".".join(path.split(".")[:i]) for path in known for i in range(1, path.count(".") + 1)} - known
+    unknown: set = set()
+    mismatched: int = 0
+    written: int = 0
+
+    docs = iter(docs)
+    with pq.ParquetWriter(w, arrow_schema) as writer:
+        while True:
+            flats: list = [flatten_document(doc, max_depth, arrays=False)
+                           for doc in itertools.islice(docs, PARQUET_ROW_GROUP_SIZE)]
+            if not flats:
+                break
+            arrays: list = []
+            for path, column_type in columns:
+                values: list = []
+                for flat in flats:
+                    value = parquet_value(column_type, flat.get(path))
+                    if value is MISSING:
+                        mismatched += 1
+                        value = None
+                    values.append(value)
+                arrays.append(pa.array(values, type=arrow_types[column_type]))
+            for flat in flats:
+                for key, value in flat.items():
+                    if key in known:
+                        continue
+                    if key not in split:
+                        unknown.add(key)
+                    elif value is not None:
+                        mismatched += 1
+            writer.write_table(pa.Table.from_arrays(arrays, schema=arrow_schema))
+            written += len(flats)
+
+    logger = get_logger()
+    if unknown:
+        logger.warning("left out %d fields the schema didn't have: %s", len(unknown), ", ".join(sorted(unknown)[:10]))
+    if mismatched:
+        logger.warning("wrote %d values as null because their type didn't match their column's", mismatched)
+    return written
+
+
+def use_color(w) -> bool:
+    """
+    Decides whether to color output written to w.
+
+    Color is used only when w is a terminal and the NO_COLOR environment variable
+    is unset or empty, following https://no-color.org.
+
+    Args:
+        w: The text file-like object being written to.
+    """
+    if os.environ.get("NO_COLOR"):
+        return False
+    isatty = getattr(w, "isatty", None)
+    return bool(isatty and isatty())
+
+
+def truncate(text: str, width: int) -> str:
+    """
+    Shortens text to at most width characters, marking the cut with an ellipsis.
+
+    Args:
+        text (str): The text to shorten.
+        width (int): The longest result allowed; 0 or less leaves text untouched.
+    """
+    if width <= 0 or len(text) <= width:
+        return text
+    return text[:width - 1] + ELLIPSIS
+
+
+def render_text_table(w, docs: list, fields: list = None, max_col_width: int = 0, color: Optional[bool] = None,
+                      max_depth: int = DEFAULT_FLATTEN_DEPTH) -> None:
+    """
+    Writes documents as a plain-text table with aligned columns.
+
+    Args:
+        w: A text file-like object to write to.
+        docs (list): The documents to write, one per row.
+        fields (None | list): Dotted paths to use as columns. If None, the documents are
+            flattened to max_depth and the keys of all of them are used, in order of first appearance.
+        max_col_width (int): Cells and headers longer than this are cut short with an
+            ellipsis; 0 means no limit.
+        color (None | bool): Whether to bold the header. If None, it is bolded only when
+            w is a terminal and NO_COLOR isn't set.
+        max_depth (int): How many levels of nesting become columns when fields is None.
+    """
+    lookup = get_path
+    if fields is None:
+        docs = [flatten_document(doc, max_depth) for doc in docs]
+        fields = list(dict.fromkeys(key for doc in docs for key in doc))
+        lookup = get_flat
+    if color is None:
+        color = use_color(w)
+
+    # a newline or tab inside a cell would break the row apart, so flatten them to spaces
+    def render_cell(value) -> str:
+        return truncate(CONTROL_WHITESPACE.sub(" ", format_value(value)), max_col_width)
+
+    header: list = [truncate(field, max_col_width) for field in fields]
+    rows: list = [[render_cell(lookup(doc, field)) for field in fields] for doc in docs]
+    widths: list = [max([len(name)] + [len(row[i]) for row in rows]) for i, name in enumerate(header)]
+
+    header_line: str = "  ".join(name.ljust(width) for name, width in zip(header, widths)).rstrip()
+    if color:
+        header_line = f"{BOLD}{header_line}{RESET}"
+    w.write(header_line + "\n")
+    w.write("  ".join("-" * width for width in widths) + "\n")
+    for row in rows:
+        w.write("  ".join(cell.ljust(width) for cell, width in zip(row, widths)).rstrip() + "\n")
@@ -0,0 +1,175 @@
+import itertools
+from dataclasses import dataclass
+from typing import Optional
+
+from exporters import export_csv, export_jsonl, export_parquet, render_text_table
+from html_views import render_html_table, render_json_tree
+from schema import DEFAULT_SAMPLE_SIZE, schema_from_documents
+from spill import SpillBuffer, SpillConfig
+
+
+@dataclass
+class RenderOptions:
+    """
+    Settings an OutputFormat may use; each format reads the ones that apply to it.
+
+    Attributes:
+        max_col_width (int): The widest a table column gets; 0 means no limit.
+        sample_size (int): How many of the first documents parquet infers its columns from.
+        spill (None | SpillConfig): Makes parquet infer its columns from every document instead,
+            buffering them within this budget.
+    """
+    max_col_width: int = 0
+    sample_size: int = DEFAULT_SAMPLE_SIZE
+    spill: Optional[SpillConfig] = None
+
+
+class OutputFormat:
+    """
+    A way of writing documents, registered under a name with register_format so -format can choose it.
+
+    Subclasses set name, and binary if they write bytes, and implement write.
+
+    Attributes:
+        name (str): The name -format takes.
+        binary (bool): Whether write takes a binary file rather than a text one.
+    """
+    name: str = ""
+    binary: bool = False
+
+    def write(self, w, docs, opts: RenderOptions) -> None:
+        """
+        Writes documents.
+
+        Args:
+            w: A text file-like object, or a binary one if binary is set.
+            docs (iterable): The documents to write.
+            opts (RenderOptions): The settings to write them with.
+        """
+        raise NotImplementedError
+
+
+# the registered formats by name, in the order -format lists them
+FORMATS: dict = {}
+
+
+def register_format(fmt: OutputFormat, replace: bool = False) -> OutputFormat:
+    """
+    Makes a format available by its name, e.g. to -format.
+
+    Args:
+        fmt (OutputFormat): The format.
+        replace (bool): Replace a format already registered under the name instead of refusing it.
+
+    Returns:
+        OutputFormat: fmt, so a module can register and keep a format in one statement.
+
+    Raises:
+        ValueError: If fmt has no name, or one already taken and replace isn't set.
+    """
+    if not fmt.name:
+        raise ValueError(f"{type(fmt).__name__} has no name to register it under")
+    if fmt.name in FORMATS and not replace:
+        raise ValueError(f"an output format named {fmt.name!r} is already registered")
+    FORMATS[fmt.name] = fmt
+    return fmt
+
+
+def get_format(name: str) -> OutputFormat:
+    """
+    Looks up a registered format.
+
+    Args:
+        name (str): The format's name.
+
+    Returns:
+        OutputFormat: The format.
+
+    Raises:
+        ValueError: If no format has that name.
+    """
+    fmt: Optional[OutputFormat] = FORMATS.get(name)
+    if fmt is None:
+        raise ValueError(f"unknown output format {name!r}, expected one of {', '.join(FORMATS)}")
+    return fmt
+
+
+def format_names() -> tuple:
+    """
+    Lists the names of the registered formats, in the order they were registered.
+    """
+    return tuple(FORMATS)
+
+
+class JsonlFormat(OutputFormat):
+    """
+    One relaxed Extended JSON document per line, streamed.
+    """
+    name = "jsonl"
+
+    def write(self, w, docs, opts: RenderOptions) -> None:
+        export_jsonl(w, docs)
+
+
+class CsvFormat(OutputFormat):
+    """
+    CSV with nested fields flattened into dotted columns, streamed.
+    """
+    name = "csv"
+
+    def write(self, w, docs, opts: RenderOptions) -> None:
+        export_csv(w, docs)
+
+
+class TableFormat(OutputFormat):
+    """
+    An aligned text table, buffered to size the columns.
+    """
+    name = "table"
+
+    def write(self, w, docs, opts: RenderOptions) -> None:
+        render_text_table(w, list(docs), max_col_width=opts.max_col_width)
+
+
+class HtmlFormat(OutputFormat):
+    """
+    A standalone HTML page with a sortable table.
+    """
+    name = "html"
+
+    def write(self, w, docs, opts: RenderOptions) -> None:
+        render_html_table(w, list(docs))
+
+
+class TreeFormat(OutputFormat):
+    """
+    A standalone HTML page of collapsible document trees.
+    """
+    name = "tree"
+
+    def write(self, w, docs, opts: RenderOptions) -> None:
+        render_json_tree(w, list(docs))
+
+
+class ParquetFormat(OutputFormat):
+    """
+    A Parquet file, with columns inferred from the head of the documents, or all of them given a spill budget.
+    """
+    name = "parquet"
+    binary = True
+
+    def write(self, w, docs, opts: RenderOptions) -> None:
+        # the columns must be known before the first row, so they come from the head of the results,
+        # or with a budget from a first pass over all of them
+        if opts.spill is not None:
+            with SpillBuffer(opts.spill) as buffered:
+                buffered.extend(docs)
+                export_parquet(w, buffered, schema_from_documents(buffered))
+            return
+        docs = iter(docs)
+        head: list = list(itertools.islice(docs, opts.sample_size))
+        export_parquet(w, itertools.chain(head, docs), schema_from_documents(head))
+
+
+for _fmt in (JsonlFormat(), CsvFormat(), TableFormat(), HtmlFormat(), TreeFormat(), ParquetFormat()):
+    register_format(_fmt)
@@ -0,0 +1,75 @@
+from dataclasses import dataclass
+
+from pymongo.collection import Collection
+
+from exporters import MISSING, format_value, get_path
+
+
+@dataclass
+class GeoPoint:
+    """
+    One location read from a GeoJSON Point.
+
+    Attributes:
+        lng (float): The longitude, in degrees.
+        lat (float): The latitude, in degrees.
+        label (str): What the point's marker is labelled with, the document's _id.
+    """
+    lng: float
+    lat: float
+    label: str = ""
+
+
+def geo_point(value) -> tuple:
+    """
+    Reads the longitude and latitude out of a GeoJSON Point.
+
+    Args:
+        value: A field value, expected to look like {"type": "Point", "coordinates": [lng, lat]}.
+
+    Returns:
+        tuple: (lng, lat), or None if value isn't a valid Point.
+    """
+    if not isinstance(value, dict) or value.get("type") != "Point":
+        return None
+    coordinates = value.get("coordinates")
+    if not isinstance(coordinates, (list, tuple)) or len(coordinates) < 2:
+        return None
+
+    lng, lat = coordinates[0], coordinates[1]
+    # bool is an int, but true/false coordinates are malformed, not (1, 0)
+    if not all(isinstance(c, (int, float)) and not isinstance(c, bool) for c in (lng, lat)):
+        return None
+    if not (-180 <= lng <= 180 and -90 <= lat <= 90):
+        return None
+    return float(lng), float(lat)
+
+
+def extract_geo_points(collection: Collection, field: str, query_filter: dict = None) -> tuple:
+    """
+    Reads the GeoJSON Point stored in a field of every matching document.
+
+    Documents where the field is missing, isn't a Point, or holds out-of-range
+    coordinates are skipped and counted rather than failing the whole read.
+
+    Args:
+        collection (Collection): The collection to read.
+        field (str): The dotted path of the GeoJSON field, e.g. address.location.
+        query_filter (None | dict): Restricts which documents are read.
+
+    Returns:
+        tuple: (points, skipped), a list of GeoPoints and the number of documents skipped.
+    """
+    points: list = []
+    skipped: int = 0
+
+    with collection.find(query_filter or {}, projection={field: 1}) as cursor:
+        for doc in cursor:
+            value = get_path(doc, field)
+            point = None if value is MISSING else geo_point(value)
+            if point is None:
+                skipped += 1
+                continue
+            points.append(GeoPoint(lng=point[0], lat=point[1], label=format_value(doc.get("_id"))))
+
+    return points, skipped
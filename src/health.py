@@ -0,0 +1,147 @@
+import threading
+from typing import Optional
+
+import pymongo
+
+from logs import get_logger
+
+
+DEFAULT_HEALTH_INTERVAL: float = 10.0
+
+# one failed ping can be a blip; this many in a row and the client is replaced
+FAILURES_BEFORE_RECONNECT: int = 3
+
+# how long stop waits for a check in progress
+STOP_TIMEOUT: float = 1.0
+
+
+class HealthMonitor:
+    """
+    Pings the server in the background and replaces the client after repeated failures.
+
+    The driver already rides out a primary stepping down. This covers what it
+    doesn't, such as a client whose SRV hosts or proxy moved, by building a new
+    client from scratch.
+
+    Attributes:
+        interval (float): Seconds between pings.
+        threshold (int): Consecutive failed pings before reconnecting.
+        failures (int): Consecutive failed pings so far.
+        last_error (None | str): Why the last ping or reconnect failed, None once one succeeds.
+    """
+
+    def __init__(self, client: pymongo.MongoClient, reconnect, interval: float = DEFAULT_HEALTH_INTERVAL,
+                 threshold: int = FAILURES_BEFORE_RECONNECT) -> None:
+        """
+        Constructs a new HealthMonitor watching client; call start to begin pinging.
+
+        Args:
+            client (pymongo.MongoClient): The connected client to hand out and watch.
+            reconnect (callable): Returns a new connected client, raising if it can't.
+            interval (float): Seconds between pings.
+            threshold (int): Consecutive failed pings before reconnecting.
+        """
+        self.interval: float = interval
+        self.threshold: int = threshold
+        self.failures: int = 0
+        self.last_error: Optional[str] = None
+        self._client: pymongo.MongoClient = client
+        self._reconnect = reconnect
+        self._lock: threading.Lock = threading.Lock()
+        self._stopped: threading.Event = threading.Event()
+        self._thread: Optional[threading.Thread] = None
+
+    @property
+    def client(self) -> pymongo.MongoClient:
+        """
+        The current client. Take it once per request, so a swap midway can't mix two clients.
+        """
+        with self._lock:
+            return self._client
+
+    @property
+    def healthy(self) -> bool:
+        """
+        Whether the last ping succeeded.
+        """
+        with self._lock:
+            return self.failures == 0
+
+    def status(self) -> dict:
+        """
+        Describes the connection for /health.
+        """
+        with self._lock:
+            status: dict = {"status": "ok" if self.failures == 0 else "unavailable", "failures": self.failures}
+            if self.last_error:
+                status["error"] = self.last_error
+            return status
+
+    def check(self) -> None:
+        """
+        Pings the server once, reconnecting if this makes threshold failures in a row.
+        """
+        client: pymongo.MongoClient = self.client
+        try:
+            client.admin.command("ping")
+        except pymongo.errors.PyMongoError as e:
+            with self._lock:
+                self.failures += 1
+                self.last_error = str(e)
+                failures: int = self.failures
+            get_logger().warning("health check ping failed (%d in a row): %s", failures, e)
+            if failures >= self.threshold:
+                self.replace(client)
+            return
+
+        with self._lock:
+            self.failures = 0
+            self.last_error = None
+
+    def replace(self, old: pymongo.MongoClient) -> None:
+        """
+        Connects a new client, swaps it in and closes the old one.
+
+        Requests already running on the old client fail, but they were failing anyway.
+
+        Args:
+            old (pymongo.MongoClient): The client being replaced.
+        """
+        logger = get_logger()
+        try:
+            new: pymongo.MongoClient = self._reconnect()
+        except Exception as e:
+            with self._lock:
+                self.last_error = f"reconnect failed: {e}"
+            logger.warning("reconnect failed: %s", e)
+            return
+
+        with self._lock:
+            self._client = new
+            self.failures = 0
+            self.last_error = None
+        old.close()
+        logger.warning("reconnected to MongoDB with a new client")
+
+    def run(self) -> None:
+        """
+        Checks the connection every interval until stopped.
+        """
+        while not self._stopped.wait(self.interval):
+            self.check()
+
+    def start(self) -> None:
+        """
+        Starts checking in a background thread.
+        """
+        self._thread = threading.Thread(target=self.run, name="health-check", daemon=True)
+        self._thread.start()
+
+    def stop(self) -> None:
+        """
+        Stops checking, waiting for a check in progress to finish.
+        """
+        self._stopped.set()
+        if self._thread is not None:
+            # a reconnect can take up to the connect timeout, so don't hang shutdown on it
+            self._thread.join(timeout=STOP_TIMEOUT)
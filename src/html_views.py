@@ -0,0 +1,320 @@
+import html
+import json
+from string import Template
+
+from bson import json_util
+
+from exporters import format_value, get_path
+
+
+TABLE_PAGE: Template = Template("""<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>$title</title>
+<style>
+  body { font-family: sans-serif; margin: 2em; }
+  table { border-collapse: collapse; }
+  th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+  th { background: #f0f0f0; cursor: pointer; user-select: none; }
+  th.asc::after { content: " \\25B2"; }
+  th.desc::after { content: " \\25BC"; }
+  tr:nth-child(even) td { background: #fafafa; }
+</style>
+</head>
+<body>
+<h1>$title</h1>
+<p>$count documents</p>
+<table id="results">
+<thead><tr>$header</tr></thead>
+<tbody>
+$rows
+</tbody>
+</table>
+<script>
+// click a header to sort by that column, numerically when every cell is a number
+document.querySelectorAll("#results th").forEach(function (th, col) {
+  th.addEventListener("click", function () {
+    var tbody = document.querySelector("#results tbody");
+    var rows = Array.prototype.slice.call(tbody.rows);
+    var asc = !th.classList.contains("asc");
+    var numeric = rows.every(function (r) {
+      var t = r.cells[col].textContent;
+      return t === "" || !isNaN(Number(t));
+    });
+    rows.sort(function (a, b) {
+      var x = a.cells[col].textContent, y = b.cells[col].textContent;
+      var cmp = numeric ? Number(x) - Number(y) : x.localeCompare(y);
+      return asc ? cmp : -cmp;
+    });
+    document.querySelectorAll("#results th").forEach(function (h) { h.classList.remove("asc", "desc"); });
+    th.classList.add(asc ? "asc" : "desc");
+    rows.forEach(function (r) { tbody.appendChild(r); });
+  });
+});
+</script>
+$live
+</body>
+</html>
+""")
+
+
+# reloads the page on every change event, or every POLL_SECONDS if the server can't stream changes
+LIVE_SCRIPT: Template = Template("""<script>
+(function () {
+  var source = new EventSource("$url");
+  source.onmessage = function () { location.reload(); };
+  source.onerror = function () {
+    // a 501 or other failed response closes the source for good, unlike a dropped connection
+    if (source.readyState === EventSource.CLOSED) {
+      setTimeout(function () { location.reload(); }, $poll_ms);
+    }
+  };
+})();
+</script>""")
+
+POLL_SECONDS: int = 30
+
+
+def render_html_table(w, docs: list, fields: list = None, title: str = "Query results", events_url: str = None) -> None:
+    """
+    Writes a standalone HTML page with a sortable table of documents.
+
+    Every header and cell is HTML-escaped, so document content can't inject markup or script.
+
+    Args:
+        w: A text file-like object to write to.
+        docs (list): The documents to render, one per row.
+        fields (None | list): Dotted paths to use as columns, in order. If None, the
+            top-level keys of all documents are used in alphabetical order.
+        title (str): The page title and heading.
+        events_url (None | str): A Server-Sent Events endpoint; if given, the page
+            reloads whenever it sends an event.
+    """
+    if fields is None:
+        fields = sorted({key for doc in docs for key in doc})
+
+    header: str = "".join(f"<th>{html.escape(field)}</th>" for field in fields)
+    rows: list = []
+    for doc in docs:
+        cells: str = "".join(f"<td>{html.escape(format_value(get_path(doc, field)))}</td>" for field in fields)
+        rows.append(f"<tr>{cells}</tr>")
+
+    live: str = ""
+    if events_url is not None:
+        live = LIVE_SCRIPT.substitute(url=json.dumps(events_url)[1:-1], poll_ms=POLL_SECONDS * 1000)
+
+    w.write(TABLE_PAGE.substitute(title=html.escape(title),
+                                  count=len(docs),
+                                  header=header,
+                                  rows="\n".join(rows),
+                                  live=live))
+
+
+MAP_PAGE: Template = Template("""<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>$title</title>
+<link rel="stylesheet" href="https://unpkg.com/leaflet@1.9.4/dist/leaflet.css">
+<script src="https://unpkg.com/leaflet@1.9.4/dist/leaflet.js"></script>
+<style>
+  body { font-family: sans-serif; margin: 0; }
+  header { padding: 0.5em 1em; }
+  #map { position: absolute; top: 4em; bottom: 0; left: 0; right: 0; }
+</style>
+</head>
+<body>
+<header><strong>$title</strong> &mdash; $count points</header>
+<div id="map"></div>
+<script>
+var points = $points;
+var map = L.map("map");
+L.tileLayer("https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png", {
+  maxZoom: 19,
+  attribution: "&copy; OpenStreetMap contributors"
+}).addTo(map);
+points.forEach(function (p) {
+  // bindPopup would parse a string as HTML, so hand it a text node instead
+  L.marker([p[0], p[1]]).addTo(map).bindPopup(document.createTextNode(p[2]));
+});
+if (points.length > 0) {
+  map.fitBounds(points.map(function (p) { return [p[0], p[1]]; }), { maxZoom: 15 });
+} else {
+  map.setView([0, 0], 2);
+}
+</script>
+</body>
+</html>
+""")
+
+
+def render_leaflet_map(w, points: list, title: str = "Map") -> None:
+    """
+    Writes a standalone HTML page with a Leaflet map and a marker for each point.
+
+    The page loads Leaflet and OpenStreetMap tiles from the web when opened.
+
+    Args:
+        w: A text file-like object to write to.
+        points (list): The GeoPoints to mark, e.g. from extract_geo_points.
+        title (str): The page title and heading.
+    """
+    # Leaflet takes [lat, lng], the reverse of GeoJSON's order
+    data: str = json.dumps([[point.lat, point.lng, point.label] for point in points])
+    # a label containing </script> would otherwise end the script block early
+    data = data.replace("</", "<\\/")
+
+    w.write(MAP_PAGE.substitute(title=html.escape(title), count=len(points), points=data))
+
+
+COMPLETENESS_PAGE: Template = Template("""<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>$title</title>
+<style>
+  body { font-family: sans-serif; margin: 2em; }
+  table { border-collapse: collapse; }
+  td { padding: 4px 8px; vertical-align: middle; }
+  .bar { display: flex; width: 30em; height: 1.2em; background: #eee; }
+  .present { background: #2e7d32; }
+  .null { background: #f9a825; }
+  .missing { background: #c62828; }
+  .key span { display: inline-block; width: 1em; height: 1em; margin: 0 0.3em 0 1em; vertical-align: middle; }
+</style>
+</head>
+<body>
+<h1>$title</h1>
+<p class="key"><span class="present"></span>present<span class="null"></span>null<span class="missing"></span>missing</p>
+<table>
+$rows
+</table>
+</body>
+</html>
+""")
+
+
+def render_completeness_html(w, completeness: dict, title: str = "Field completeness") -> None:
+    """
+    Writes a standalone HTML page with a stacked bar per field of its present, null and missing counts.
+
+    Args:
+        w: A text file-like object to write to.
+        completeness (dict): Maps field paths to Completeness, e.g. from field_completeness.
+        title (str): The page title and heading.
+    """
+    rows: list = []
+    for name, c in completeness.items():
+        segments: str = ""
+        for kind, count in (("present", c.present), ("null", c.null), ("missing", c.missing)):
+            if c.total and count:
+                width: float = 100 * count / c.total
+                segments += f'<div class="{kind}" style="width: {width:.2f}%" title="{kind}: {count}"></div>'
+        rows.append(f"<tr><td>{html.escape(name)}</td><td><div class=\"bar\">{segments}</div></td>"
+                    f"<td>{c.present}/{c.total}</td></tr>")
+
+    w.write(COMPLETENESS_PAGE.substitute(title=html.escape(title), rows="\n".join(rows)))
+
+
+TREE_PAGE: Template = Template("""<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>$title</title>
+<style>
+  body { font-family: sans-serif; margin: 2em; }
+  #docs { font-family: monospace; }
+  #docs details, #docs .leaf { margin-left: 1.5em; }
+  #docs > details { margin: 0 0 0.5em 0; }
+  summary { cursor: pointer; }
+  .key { color: #444; }
+  .size { color: #999; }
+  .string { color: #2e7d32; }
+  .number { color: #1565c0; }
+  .boolean, .null { color: #6a1b9a; }
+  .bson { color: #c62828; }
+</style>
+</head>
+<body>
+<h1>$title</h1>
+<p>$count documents <button id="expand">Expand all</button> <button id="collapse">Collapse all</button></p>
+<div id="docs"></div>
+<script>
+var docs = $docs;
+
+function span(text, cls) {
+  var s = document.createElement("span");
+  s.className = cls;
+  s.textContent = text;
+  return s;
+}
+
+// a one-key object such as {"$$oid": ...} or {"$$date": ...} is a BSON value, shown inline rather than folded
+function isBson(value) {
+  var keys = Object.keys(value);
+  return keys.length === 1 && keys[0].charAt(0) === "$$";
+}
+
+function node(key, value, open) {
+  if (value !== null && typeof value === "object" && !isBson(value)) {
+    var details = document.createElement("details");
+    details.open = open;
+    var summary = document.createElement("summary");
+    var keys = Object.keys(value);
+    summary.appendChild(span(key, "key"));
+    summary.appendChild(span(Array.isArray(value) ? " [" + keys.length + "]" : " {" + keys.length + "}", "size"));
+    details.appendChild(summary);
+    keys.forEach(function (k) { details.appendChild(node(k + ": ", value[k], false)); });
+    return details;
+  }
+  var leaf = document.createElement("div");
+  leaf.className = "leaf";
+  leaf.appendChild(span(key, "key"));
+  var type = value === null ? "null" : typeof value === "object" ? "bson" : typeof value;
+  leaf.appendChild(span(JSON.stringify(value), type));
+  return leaf;
+}
+
+var root = document.getElementById("docs");
+docs.forEach(function (doc, i) {
+  var id = doc !== null && typeof doc === "object" && "_id" in doc ? " _id " + JSON.stringify(doc._id) : "";
+  root.appendChild(node("#" + (i + 1) + id, doc, i === 0));
+});
+document.getElementById("expand").addEventListener("click", function () {
+  root.querySelectorAll("details").forEach(function (d) { d.open = true; });
+});
+document.getElementById("collapse").addEventListener("click", function () {
+  root.querySelectorAll("details").forEach(function (d) { d.open = false; });
+});
+</script>
+$live
+</body>
+</html>
+""")
+
+
+def render_json_tree(w, docs: list, title: str = "Documents", events_url: str = None) -> None:
+    """
+    Writes a standalone HTML page showing each document as a collapsible tree.
+
+    Suits deeply nested documents that a table would flatten into unreadable cells.
+    Documents are embedded as relaxed Extended JSON, so an ObjectId shows as
+    {"$oid": ...} and a date as {"$date": ...}, and the page builds the tree from
+    text nodes, so document content can't inject markup or script.
+
+    Args:
+        w: A text file-like object to write to.
+        docs (list): The documents to render.
+        title (str): The page title and heading.
+        events_url (None | str): A Server-Sent Events endpoint; if given, the page
+            reloads whenever it sends an event.
+    """
+    # JSON only has < inside strings, where \u003c means the same, and it could otherwise end the script block
+    data: str = json_util.dumps(docs, json_options=json_util.RELAXED_JSON_OPTIONS).replace("<", "\\u003c")
+
+    live: str = ""
+    if events_url is not None:
+        live = LIVE_SCRIPT.substitute(url=json.dumps(events_url)[1:-1], poll_ms=POLL_SECONDS * 1000)
+
+    w.write(TREE_PAGE.substitute(title=html.escape(title), count=len(docs), docs=data, live=live))
@@ -0,0 +1,953 @@
+import csv
+import glob
+import io
+import itertools
+import json
+import logging
+import os
+from dataclasses import dataclass, field
+from typing import Optional
+
+import pymongo
+from bson import json_util
+from pymongo import ReplaceOne
+from pymongo.collection import Collection
+from pymongo.results import InsertManyResult
+
+from checkpoint import Checkpoint
+from coercion import CoercionReport, coerce_document, coercion_targets, json_schema_targets, parse_bool
+from exporters import MISSING, get_path
+from logs import get_logger
+from schema import DEFAULT_SAMPLE_SIZE, infer_schema, print_schema, schema_from_documents
+from validation import SchemaValidationError, skip_invalid, validate_documents
+
+
+# how many documents go into one insert_many call unless told otherwise
+DEFAULT_BATCH_SIZE: int = 1000
+
+
+class BatchInsertError(Exception):
+    """
+    Raised when a batch fails partway through an import.
+
+    Attributes:
+        inserted (int): How many documents were inserted before the failing batch.
+        replaced (int): How many existing documents an upsert replaced before it.
+    """
+
+    def __init__(self, message: str, inserted: int, replaced: int = 0) -> None:
+        """
+        Constructs a new BatchInsertError.
+
+        Args:
+            message (str): What went wrong.
+            inserted (int): How many documents were inserted before the failing batch.
+            replaced (int): How many existing documents an upsert replaced before it.
+        """
+        super().__init__(message)
+        self.inserted: int = inserted
+        self.replaced: int = replaced
+
+
+# the server error code for a write that would duplicate a unique index key
+DUPLICATE_KEY: int = 11000
+
+
+@dataclass
+class WriteFailure:
+    """
+    One document the server refused during an unordered import.
+
+    Attributes:
+        index (int): The document's position among those sent, starting at 0.
+        code (int): The server error code, e.g. DUPLICATE_KEY.
+        message (str): The server's error message.
+    """
+    index: int
+    code: int
+    message: str
+
+
+@dataclass
+class ImportReport:
+    """
+    What an import did.
+
+    Attributes:
+        inserted (int): Documents newly inserted, or that would be in a dry run.
+        replaced (int): Existing documents matched and replaced by an upsert.
+        failed (list): A WriteFailure for each document the server refused.
+        coercion (None | CoercionReport): The values converted to their fields' types, if coercing.
+    """
+    inserted: int = 0
+    replaced: int = 0
+    failed: list = field(default_factory=list)
+    coercion: Optional[CoercionReport] = None
+
+    @property
+    def written(self) -> int:
+        """
+        Documents inserted or replaced.
+        """
+        return self.inserted + self.replaced
+
+    def summary(self) -> str:
+        """
+        Describes the outcome in one line, e.g. "inserted 980, 20 duplicates skipped".
+        """
+        parts: list = [f"inserted {self.inserted}"]
+        if self.replaced:
+            parts.append(f"replaced {self.replaced}")
+        duplicates: int = sum(1 for failure in self.failed if failure.code == DUPLICATE_KEY)
+        if duplicates:
+            parts.append(f"{duplicates} duplicates skipped")
+        if len(self.failed) > duplicates:
+            parts.append(f"{len(self.failed) - duplicates} failed")
+        if self.coercion is not None and self.coercion.total:
+            parts.append(f"coerced {self.coercion.total} values")
+        return ", ".join(parts)
+
+
+@dataclass
+class ImportOptions:
+    """
+    Options shared by every importer.
+
+    Attributes:
+        batch_size (int): The most documents sent in one insert_many call.
+        dry_run (bool): Report what would be inserted without touching the database.
+        upsert_key (None | str): Replace documents matching on this field instead of inserting duplicates.
+        ordered (bool): Stop at the first document the server refuses, instead of writing
+            the rest and reporting the refusals.
+        json_schema (None | dict): A JSON Schema every document must match.
+        strict (bool): Refuse the whole import if any document fails json_schema, instead of skipping it.
+        field_map (dict): Renames fields before writing, mapping each dotted path to its new one.
+        drop (list): Dotted paths of fields removed before writing.
+        checkpoint (None | Checkpoint): Records progress after every batch, and skips what it
+            says was already written, so an interrupted import can resume.
+        coerce (bool): Convert strings to the type their field has in the collection or json_schema, such as
+            "42" to 42, before validating and writing.
+        sample_size (int): How many of the collection's documents coerce infers its field types from.
+    """
+    batch_size: int = DEFAULT_BATCH_SIZE
+    dry_run: bool = False
+    upsert_key: Optional[str] = None
+    ordered: bool = False
+    json_schema: Optional[dict] = None
+    strict: bool = False
+    field_map: dict = field(default_factory=dict)
+    drop: list = field(default_factory=list)
+    checkpoint: Optional[Checkpoint] = None
+    coerce: bool = False
+    sample_size: int = DEFAULT_SAMPLE_SIZE
+
+
+def batched(docs, size: int):
+    """
+    Splits documents into lists of at most size, reading the input lazily.
+
+    Args:
+        docs (iterable): The documents to split.
+        size (int): The largest batch to yield.
+    """
+    if size <= 0:
+        raise ValueError(f"batch size must be positive, got {size}")
+
+    iterator = iter(docs)
+    while True:
+        batch: list = list(itertools.islice(iterator, size))
+        if not batch:
+            return
+        yield batch
+
+
+def write_failures(error: pymongo.errors.BulkWriteError, offset: int) -> list:
+    """
+    Lists the documents a bulk write refused.
+
+    Args:
+        error (BulkWriteError): The error raised by an unordered insert_many or bulk_write.
+        offset (int): How many documents were sent in earlier batches.
+
+    Returns:
+        list: A WriteFailure per refused document, indexed across the whole import.
+    """
+    return [WriteFailure(index=offset + e["index"], code=e.get("code", 0), message=e.get("errmsg", ""))
+            for e in error.details.get("writeErrors", [])]
+
+
+def import_documents(collection: Collection, docs, batch_size: int = DEFAULT_BATCH_SIZE,
+                     ordered: bool = False, on_batch=None) -> ImportReport:
+    """
+    Inserts arbitrary documents into a MongoDB collection in batches.
+
+    Batching keeps each insert_many under the server's 16MB message limit and lets
+    docs be a generator, so the whole import never has to sit in memory at once.
+
+    Unordered, a refused document such as a duplicate key is recorded and the rest
+    are still inserted, so re-running a partly loaded import fills in what's missing.
+
+    Args:
+        collection (Collection): The collection to insert into.
+        docs (iterable): The documents to insert, as dicts of any shape.
+        batch_size (int): The most documents sent in one insert_many call.
+        ordered (bool): Stop at the first refused document instead of carrying on.
+        on_batch (None | callable): Called after each batch with how many documents have been sent so far.
+
+    Returns:
+        ImportReport: How many documents were inserted and which were refused.
+
+    Raises:
+        BatchInsertError: If a batch fails outright, or a document is refused while
+            ordered, carrying the count inserted before it.
+    """
+    logger = get_logger()
+    report: ImportReport = ImportReport()
+    offset: int = 0
+
+    # insert_many refuses an empty list, so an empty import simply yields no batches
+    for number, batch in enumerate(batched(docs, batch_size), start=1):
+        try:
+            result: InsertManyResult = collection.insert_many(batch, ordered=ordered)
+            inserted: int = len(result.inserted_ids)
+        except pymongo.errors.BulkWriteError as e:
+            if ordered:
+                report.inserted += e.details.get("nInserted", 0)
+                raise BatchInsertError(f"batch {number} stopped after {report.inserted} documents were inserted into "
+                                       f"{collection.name}: {e}", report.inserted) from e
+            inserted = e.details.get("nInserted", 0)
+            report.failed.extend(write_failures(e, offset))
+        except pymongo.errors.PyMongoError as e:
+            raise BatchInsertError(f"batch {number} failed after {report.inserted} documents were inserted into "
+                                   f"{collection.name}: {e}", report.inserted) from e
+        report.inserted += inserted
+        offset += len(batch)
+        logger.debug("batch %d: inserted %d documents into %s", number, inserted, collection.name)
+        if on_batch is not None:
+            on_batch(offset)
+
+    logger.info("%s into %s", report.summary(), collection.name)
+    return report
+
+
+def upsert_documents(collection: Collection, docs, key_field: str, batch_size: int = DEFAULT_BATCH_SIZE,
+                     ordered: bool = False, on_batch=None) -> ImportReport:
+    """
+    Replaces documents that share key_field with an existing one and inserts the rest.
+
+    Running the same import twice leaves the collection unchanged the second time,
+    as long as key_field uniquely identifies each document.
+
+    Args:
+        collection (Collection): The collection to write to.
+        docs (iterable): The documents to write.
+        key_field (str): The dotted path of the field identifying a document.
+        batch_size (int): The most documents sent in one bulk_write call.
+        ordered (bool): Stop at the first refused document instead of carrying on.
+        on_batch (None | callable): Called after each batch with how many documents have been sent so far.
+
+    Returns:
+        ImportReport: How many documents were inserted and replaced, and which were refused.
+
+    Raises:
+        ValueError: If a document has no value for key_field.
+        BatchInsertError: If a batch fails outright, or a document is refused while
+            ordered, carrying the counts inserted and replaced before it.
+    """
+    logger = get_logger()
+    report: ImportReport = ImportReport()
+    offset: int = 0
+
+    for number, batch in enumerate(batched(docs, batch_size), start=1):
+        requests: list = []
+        for i, doc in enumerate(batch, start=offset):
+            key = get_path(doc, key_field)
+            # without a key the document can never be matched again, so a rerun would duplicate it
+            if key is MISSING:
+                raise ValueError(f"document {i} has no {key_field!r} field to upsert on")
+            requests.append(ReplaceOne({key_field: key}, doc, upsert=True))
+
+        try:
+            result = collection.bulk_write(requests, ordered=ordered)
+            matched, upserted = result.matched_count, result.upserted_count
+        except pymongo.errors.BulkWriteError as e:
+            matched, upserted = e.details.get("nMatched", 0), e.details.get("nUpserted", 0)
+            if ordered:
+                report.replaced += matched
+                report.inserted += upserted
+                raise BatchInsertError(f"batch {number} stopped after {report.written} documents were written to "
+                                       f"{collection.name}: {e}", report.inserted, report.replaced) from e
+            report.failed.extend(write_failures(e, offset))
+        except pymongo.errors.PyMongoError as e:
+            raise BatchInsertError(f"batch {number} failed after {report.written} documents were written to "
+                                   f"{collection.name}: {e}", report.inserted, report.replaced) from e
+        report.replaced += matched
+        report.inserted += upserted
+        offset += len(batch)
+        logger.debug("batch %d: matched %d, upserted %d in %s", number, matched, upserted, collection.name)
+        if on_batch is not None:
+            on_batch(offset)
+
+    logger.info("upserted into %s on %s: %s", collection.name, key_field, report.summary())
+    return report
+
+
+def _pop_path(doc: dict, path: str):
+    """
+    Removes the field at a dotted path, such as address.zip, from a document.
+
+    Args:
+        doc (dict): The document to change.
+        path (str): The dotted path; only subdocuments are followed, not array indexes.
+
+    Returns:
+        The value removed, or MISSING if there was none.
+    """
+    *parents, last = path.split(".")
+    for key in parents:
+        doc = doc.get(key)
+        if not isinstance(doc, dict):
+            return MISSING
+    return doc.pop(last, MISSING)
+
+
+def _set_path(doc: dict, path: str, value) -> None:
+    """
+    Sets the field at a dotted path, creating subdocuments along the way.
+
+    Args:
+        doc (dict): The document to change.
+        path (str): The dotted path.
+        value: The value to set.
+    """
+    *parents, last = path.split(".")
+    for key in parents:
+        # a scalar in the way is replaced, as $set would refuse and the import has no one to ask
+        if not isinstance(doc.get(key), dict):
+            doc[key] = {}
+        doc = doc[key]
+    doc[last] = value
+
+
+def transform_document(doc: dict, field_map: dict, drop: list) -> dict:
+    """
+    Renames and removes fields so a document matches the collection's schema rather than its source's.
+
+    Drops happen first and name fields as they are in the source. A renamed field
+    moves to the end of its subdocument and overwrites a field already at the new
+    name, as $rename does.
+
+    Args:
+        doc (dict): The document, which is changed in place.
+        field_map (dict): Maps dotted paths to the paths they are renamed to.
+        drop (list): Dotted paths of fields to remove.
+
+    Returns:
+        dict: doc, changed.
+    """
+    for path in drop:
+        _pop_path(doc, path)
+    # taken all at once, so swapping a:b,b:a moves both values rather than losing one
+    moved: list = [(new, _pop_path(doc, old)) for old, new in field_map.items()]
+    for new, value in moved:
+        if value is not MISSING:
+            _set_path(doc, new, value)
+    return doc
+
+
+def apply_transform(docs, opts: ImportOptions):
+    """
+    Renames and drops fields as the import's options say, lazily so it works batch by batch.
+
+    Args:
+        docs (iterable): The parsed documents.
+        opts (ImportOptions): The import settings.
+
+    Returns:
+        iterable: The documents, transformed.
+    """
+    if not opts.field_map and not opts.drop:
+        return docs
+    return (transform_document(doc, opts.field_map, opts.drop) for doc in docs)
+
+
+def apply_coercion(collection: Collection, docs, opts: ImportOptions, report: CoercionReport):
+    """
+    Converts strings to the types their fields should have, if the import's options ask for it.
+
+    The types come from a sample of the collection, so an import matches what's already
+    there, with any the JSON Schema declares taking precedence: a field it types as a
+    string, say, is left alone whatever the collection holds. A dry run doesn't read
+    the collection, so it only coerces to the JSON Schema's types.
+
+    Args:
+        collection (Collection): The collection being imported into.
+        docs (iterable): The parsed documents.
+        opts (ImportOptions): The import settings.
+        report (CoercionReport): Receives what was converted and what couldn't be.
+
+    Returns:
+        iterable: The documents, coerced lazily.
+    """
+    if not opts.coerce:
+        return docs
+    logger = get_logger()
+    targets: dict = {}
+    if opts.dry_run:
+        logger.warning("a dry run doesn't sample %s, so -coerce only uses the types -validate-schema declares",
+                       collection.name)
+    else:
+        targets = coercion_targets(infer_schema(collection, opts.sample_size))
+    if opts.json_schema is not None:
+        for path, target in json_schema_targets(opts.json_schema).items():
+            if target:
+                targets[path] = target
+            else:
+                targets.pop(path, None)
+    if not targets:
+        logger.warning("-coerce found no field types to coerce to in %s or the schema", collection.name)
+        return docs
+    return (coerce_document(doc, targets, report, index) for index, doc in enumerate(docs))
+
+
+def log_coercion(report: CoercionReport) -> None:
+    """
+    Logs each string coercion couldn't convert, at info level as there may be many; ImportReport.coercion sums them up.
+
+    Args:
+        report (CoercionReport): What coercion did.
+    """
+    logger = get_logger()
+    for failure in report.failed:
+        logger.info("%s", failure)
+
+
+def apply_json_schema(docs, opts: ImportOptions, failures: list):
+    """
+    Holds documents up against the import's JSON Schema, if it has one.
+
+    Args:
+        docs (iterable): The parsed documents.
+        opts (ImportOptions): The import settings.
+        failures (list): Receives a ValidationError for each failure of a skipped document.
+
+    Returns:
+        iterable: The documents to write. Without strict this stays lazy and leaves out
+            documents that fail; with strict every document is checked before any is returned.
+
+    Raises:
+        SchemaValidationError: If strict is set and any document fails.
+    """
+    if opts.json_schema is None:
+        return docs
+    if not opts.strict:
+        return skip_invalid(docs, opts.json_schema, failures)
+
+    docs = list(docs)
+    errors: list = validate_documents(docs, opts.json_schema)
+    if errors:
+        raise SchemaValidationError(errors)
+    return docs
+
+
+def write_documents(collection: Collection, docs, opts: ImportOptions, source: str = "<stdin>") -> ImportReport:
+    """
+    Writes parsed documents using the insert or upsert strategy the options call for.
+
+    Fields are renamed and dropped first, then coerced if asked. Documents then failing the import's JSON
+    Schema are skipped with a warning, or refuse the whole import in strict mode.
+    With a checkpoint, the documents it says were already written are skipped, and
+    it is updated after each batch.
+
+    Args:
+        collection (Collection): The collection to write to.
+        docs (iterable): The documents to write.
+        opts (ImportOptions): The import settings.
+        source (str): The file or stream the documents came from, which the checkpoint records progress under.
+
+    Returns:
+        ImportReport: What was written and what the server refused.
+
+    Raises:
+        SchemaValidationError: If strict is set and any document fails the schema.
+    """
+    logger = get_logger()
+    checkpoint: Optional[Checkpoint] = opts.checkpoint
+    if checkpoint is not None and checkpoint.done(source):
+        logger.info("skipping %s, which the checkpoint says was already imported", source)
+        return ImportReport()
+
+    failures: list = []
+    coercion: CoercionReport = CoercionReport()
+    # the schema describes the collection, so it checks documents as they'll be written
+    docs = apply_json_schema(apply_coercion(collection, apply_transform(docs, opts), opts, coercion), opts, failures)
+
+    start: int = 0
+    on_batch = None
+    if checkpoint is not None:
+        start = checkpoint.offset(source)
+        if start:
+            logger.info("resuming %s after the %d documents already written", source, start)
+            docs = itertools.islice(docs, start, None)
+
+        def on_batch(sent: int) -> None:
+            checkpoint.advance(source, start + sent)
+
+    if opts.upsert_key:
+        report: ImportReport = upsert_documents(collection, docs, opts.upsert_key, opts.batch_size, opts.ordered, on_batch)
+    else:
+        report = import_documents(collection, docs, opts.batch_size, opts.ordered, on_batch)
+    if checkpoint is not None:
+        checkpoint.finish(source)
+    if opts.coerce:
+        report.coercion = coercion
+        log_coercion(coercion)
+    # the writers count from where they began, which on resume isn't the start of the source
+    for failure in report.failed:
+        failure.index += start
+
+    for failure in report.failed:
+        # duplicates are the expected result of re-running an import, so only show them with -v
+        level: int = logging.INFO if failure.code == DUPLICATE_KEY else logging.WARNING
+        logger.log(level, "document %d refused: %s", failure.index, failure.message)
+    for failure in failures:
+        logger.warning("skipped %s", failure)
+    if failures:
+        skipped: int = len({failure.index for failure in failures})
+        logger.warning("skipped %d documents that don't match the schema", skipped)
+    return report
+
+
+def report_dry_run(collection: Collection, docs, opts: ImportOptions = None) -> ImportReport:
+    """
+    Prints what an import would insert, and the schema of those documents, without inserting them.
+
+    Args:
+        collection (Collection): The collection the documents would go into.
+        docs (iterable): The parsed documents.
+        opts (None | ImportOptions): The import settings, whose JSON Schema is checked too.
+
+    Returns:
+        ImportReport: The number of documents that would be inserted.
+
+    Raises:
+        SchemaValidationError: If strict is set and any document fails the schema.
+    """
+    opts = opts or ImportOptions()
+    failures: list = []
+    coercion: CoercionReport = CoercionReport()
+    docs = list(apply_json_schema(apply_coercion(collection, apply_transform(docs, opts), opts, coercion), opts, failures))
+
+    for line in coercion.lines():
+        print(f"dry run: {line}")
+    for failure in coercion.failed:
+        print(f"dry run: {failure}, would leave it as a string")
+    for failure in failures:
+        print(f"dry run: would skip {failure}")
+    print(f"dry run: would insert {len(docs)} documents into {collection.name}")
+    if docs:
+        print_schema(schema_from_documents(docs))
+    return ImportReport(inserted=len(docs))
+
+
+# how much of a JSON stream is read at a time
+READ_CHUNK_SIZE: int = 1 << 16
+
+
+class _JSONStream:
+    """
+    A text stream read into a buffer on demand, remembering how many bytes came before the buffer.
+    """
+
+    def __init__(self, r) -> None:
+        """
+        Constructs a new _JSONStream.
+
+        Args:
+            r: A text file-like object to read from.
+        """
+        self.r = r
+        self.buffer: str = ""
+        self.idx: int = 0
+        self.offset: int = 0
+        self.eof: bool = False
+
+    def fill(self, at_least: int = READ_CHUNK_SIZE) -> bool:
+        """
+        Reads more text onto the buffer, dropping the part already consumed.
+
+        Args:
+            at_least (int): How many characters to ask for.
+
+        Returns:
+            bool: False if the stream was already exhausted.
+        """
+        if self.eof:
+            return False
+        self.offset += len(self.buffer[:self.idx].encode("utf-8"))
+        self.buffer = self.buffer[self.idx:]
+        self.idx = 0
+
+        chunk: str = self.r.read(at_least)
+        if not chunk:
+            self.eof = True
+            return False
+        self.buffer += chunk
+        return True
+
+    def peek(self) -> str:
+        """
+        Skips whitespace and returns the next character without consuming it, or "" at the end.
+        """
+        while True:
+            while self.idx < len(self.buffer) and self.buffer[self.idx].isspace():
+                self.idx += 1
+            if self.idx < len(self.buffer):
+                return self.buffer[self.idx]
+            if not self.fill():
+                return ""
+
+    def error(self, message: str, pos: int = None) -> ValueError:
+        """
+        Builds a ValueError pointing at a position in the buffer as a byte offset into the whole stream.
+
+        Args:
+            message (str): What went wrong.
+            pos (None | int): The position in the buffer, defaulting to the current one.
+        """
+        pos = self.idx if pos is None else pos
+        return ValueError(f"malformed JSON at byte offset {self.offset + len(self.buffer[:pos].encode('utf-8'))}: {message}")
+
+    def decode(self, decoder: json.JSONDecoder):
+        """
+        Decodes the next JSON value, reading more of the stream until it is complete.
+        """
+        while True:
+            try:
+                value, self.idx = decoder.raw_decode(self.buffer, self.idx)
+                return value
+            except json.JSONDecodeError as e:
+                # ask for as much again as is buffered, so a value spanning many chunks is parsed O(n) times, not O(n^2)
+                if not self.fill(max(READ_CHUNK_SIZE, len(self.buffer))):
+                    raise self.error(e.msg, e.pos) from e
+
+
+def iter_json_documents(r):
+    """
+    Parses documents from a stream holding a single object, an array of objects, or newline-delimited objects.
+
+    The stream is read in chunks and documents are yielded as soon as they are
+    complete, so arbitrarily large input never has to fit in memory. Extended JSON
+    wrappers such as {"$date": ...} and {"$oid": ...} are decoded into their BSON types.
+
+    Args:
+        r: A text file-like object, such as an open file or sys.stdin.
+
+    Yields:
+        dict: Each parsed document, in order.
+
+    Raises:
+        ValueError: If the input is malformed, with the byte offset of the problem.
+    """
+    decoder: json.JSONDecoder = json.JSONDecoder(object_hook=json_util.object_hook)
+    stream: _JSONStream = _JSONStream(r)
+
+    def check(value) -> dict:
+        if not isinstance(value, dict):
+            raise ValueError(f"expected JSON objects, found {type(value).__name__}")
+        return value
+
+    # decode one value at a time so a plain array, a lone object and mongoexport-style
+    # newline-delimited objects all go through the same loop
+    while True:
+        c: str = stream.peek()
+        if not c:
+            return
+        if c != "[":
+            yield check(stream.decode(decoder))
+            continue
+
+        # step inside a top-level array instead of decoding it whole, so it streams too
+        stream.idx += 1
+        if stream.peek() == "]":
+            stream.idx += 1
+            continue
+        while True:
+            if not stream.peek():
+                raise stream.error("unterminated array")
+            yield check(stream.decode(decoder))
+            c = stream.peek()
+            stream.idx += 1
+            if c == "]":
+                break
+            if c != ",":
+                raise stream.error("expected ',' or ']' in array", stream.idx - 1)
+
+
+def parse_json_documents(text: str) -> list:
+    """
+    Parses JSON text holding a single object, an array of objects, or newline-delimited objects.
+
+    Extended JSON wrappers such as {"$date": ...} and {"$oid": ...} are decoded into their BSON types.
+
+    Args:
+        text (str): The JSON text to parse.
+
+    Returns:
+        list: The parsed documents, empty if the text is blank.
+
+    Raises:
+        ValueError: If the text is malformed, with the byte offset of the problem.
+    """
+    return list(iter_json_documents(io.StringIO(text)))
+
+
+def import_json_reader(collection: Collection, r, opts: ImportOptions = None,
+                       name: str = "<stdin>") -> ImportReport:
+    """
+    Inserts the documents read from a JSON stream into a MongoDB collection, batch by batch as they are parsed.
+
+    Because input is parsed as it is inserted, malformed JSON partway through is
+    only found after the batches before it have been written.
+
+    Args:
+        collection (Collection): The collection to insert into.
+        r: A text file-like object holding one object, an array of objects, or one object per line.
+        opts (None | ImportOptions): Batch size, dry-run and upsert settings.
+        name (str): What to call the input in error messages.
+
+    Returns:
+        ImportReport: What was written, or would have been in a dry run, and what the server refused.
+
+    Raises:
+        ValueError: If the input is not valid JSON or holds something other than objects.
+        SchemaValidationError: If strict is set and any document fails the schema.
+        BatchInsertError: If a batch fails partway through, or a document is refused while ordered.
+    """
+    opts = opts or ImportOptions()
+
+    try:
+        if opts.dry_run:
+            return report_dry_run(collection, iter_json_documents(r), opts)
+
+        return write_documents(collection, iter_json_documents(r), opts, name)
+    except SchemaValidationError as e:
+        # raised again as its own type, so callers can still tell it apart from bad input
+        raise SchemaValidationError(e.failures, name) from e
+    except ValueError as e:
+        raise ValueError(f"{name}: {e}") from e
+
+
+def import_json_file(collection: Collection, path: str, opts: ImportOptions = None) -> ImportReport:
+    """
+    Inserts the documents from a JSON file into a MongoDB collection.
+
+    Args:
+        collection (Collection): The collection to insert into.
+        path (str): Path to a file holding one object, an array of objects, or one object per line.
+        opts (None | ImportOptions): Batch size, dry-run and upsert settings.
+
+    Returns:
+        ImportReport: What was written, or would have been in a dry run, and what the server refused.
+
+    Raises:
+        ValueError: If the file is not valid JSON or holds something other than objects.
+        BatchInsertError: If a batch fails partway through, or a document is refused while ordered.
+    """
+    with open(path, encoding="utf-8") as f:
+        return import_json_reader(collection, f, opts, name=path)
+
+
+# maps the type names accepted in type hints to the function that converts a cell
+CSV_CONVERTERS: dict = {
+    "str": str,
+    "string": str,
+    "int": int,
+    "float": float,
+    "bool": parse_bool,
+}
+
+
+def iter_csv_documents(reader, header: list, path: str, type_hints: dict, warnings: list):
+    """
+    Yields one document per data row of a CSV file, reading it lazily so it can be written batch by batch.
+
+    Args:
+        reader: A csv.reader positioned after the header row.
+        header (list): The field names.
+        path (str): The file being read, for the messages.
+        type_hints (dict): Maps column names to keys of CSV_CONVERTERS; unlisted columns stay strings.
+        warnings (list): Receives a warning for each row skipped for its column count.
+
+    Raises:
+        ValueError: If a hinted cell can't be converted.
+    """
+    for row in reader:
+        # line_num counts physical lines, so warnings point at the right place even with quoted newlines
+        line: int = reader.line_num
+        if not row:
+            continue
+        if len(row) != len(header):
+            warnings.append(f"{path}:{line}: skipped row with {len(row)} columns, header has {len(header)}")
+            continue
+
+        doc: dict = {}
+        for field, value in zip(header, row):
+            convert = CSV_CONVERTERS[type_hints.get(field, "str")]
+            try:
+                doc[field] = convert(value)
+            except ValueError as e:
+                raise ValueError(f"{path}:{line}: column {field!r}: {e}") from e
+        yield doc
+
+
+def import_csv_file(collection: Collection, path: str, type_hints: dict = None, opts: ImportOptions = None) -> tuple:
+    """
+    Inserts one document per data row of a CSV file, using the header row as field names.
+
+    Rows are read as they are inserted, so only a batch is held in memory at a time,
+    and a cell that can't be converted is only found after the batches before it
+    have been written.
+
+    Args:
+        collection (Collection): The collection to insert into.
+        path (str): Path to the CSV file.
+        type_hints (None | dict): Maps column names to "int", "float" or "bool"; unlisted columns stay strings.
+        opts (None | ImportOptions): Batch size, dry-run and upsert settings.
+
+    Returns:
+        tuple: The ImportReport of what was written (or would have been, in a dry run)
+            and a list of warnings for skipped rows.
+
+    Raises:
+        ValueError: If a type hint is unknown or a hinted cell can't be converted.
+        BatchInsertError: If a batch fails partway through, or a document is refused while ordered.
+    """
+    opts = opts or ImportOptions()
+    type_hints = type_hints or {}
+    for column, type_name in type_hints.items():
+        if type_name not in CSV_CONVERTERS:
+            raise ValueError(f"unknown type {type_name!r} for column {column!r}, expected one of {sorted(CSV_CONVERTERS)}")
+
+    warnings: list = []
+
+    with open(path, newline="", encoding="utf-8") as f:
+        reader = csv.reader(f)
+        header: list = next(reader, None)
+        if header is None:
+            return ImportReport(), warnings
+
+        docs = iter_csv_documents(reader, header, path, type_hints, warnings)
+        if opts.dry_run:
+            return report_dry_run(collection, docs, opts), warnings
+        return write_documents(collection, docs, opts, path), warnings
+
+
+# the files import_directory picks up unless told otherwise
+DEFAULT_IMPORT_PATTERN: str = "*.json"
+
+
+@dataclass
+class FileImport:
+    """
+    The outcome of importing one file of a directory.
+
+    Attributes:
+        report (ImportReport): What was written from the file, including any batches before a failure.
+        error (None | str): Why the file stopped importing, naming the file, None if it finished.
+        warnings (list): Rows skipped from a CSV file.
+    """
+    report: ImportReport = field(default_factory=ImportReport)
+    error: Optional[str] = None
+    warnings: list = field(default_factory=list)
+
+
+@dataclass
+class DirectoryReport:
+    """
+    What importing a directory of files did, file by file.
+
+    Attributes:
+        files (dict): Maps each file's name, in the order imported, to its FileImport.
+    """
+    files: dict = field(default_factory=dict)
+
+    @property
+    def inserted(self) -> int:
+        """
+        Documents inserted across every file.
+        """
+        return sum(result.report.inserted for result in self.files.values())
+
+    @property
+    def replaced(self) -> int:
+        """
+        Existing documents replaced by an upsert across every file.
+        """
+        return sum(result.report.replaced for result in self.files.values())
+
+    def summary(self) -> str:
+        """
+        Describes the outcome in one line, e.g. "inserted 20, replaced 980 from 3 files".
+        """
+        written: str = f"inserted {self.inserted}"
+        if self.replaced:
+            written += f", replaced {self.replaced}"
+        return f"{written} from {len(self.files)} files"
+
+    @property
+    def failed(self) -> list:
+        """
+        The names of the files that stopped with an error.
+        """
+        return [name for name, result in self.files.items() if result.error is not None]
+
+
+def import_directory(collection: Collection, directory: str, pattern: str = DEFAULT_IMPORT_PATTERN,
+                     opts: ImportOptions = None, type_hints: dict = None) -> DirectoryReport:
+    """
+    Imports every file in a directory whose name matches pattern, such as an export split into parts.
+
+    Files are imported in name order, .csv files as CSV and the rest as JSON. A file
+    that fails is recorded and the import carries on with the next, so one bad part
+    doesn't hold up the rest; rerun with -upsert-key to retry only the failures safely.
+
+    Args:
+        collection (Collection): The collection to insert into.
+        directory (str): The directory to read; subdirectories aren't searched.
+        pattern (str): A glob matched against file names, e.g. "*.json".
+        opts (None | ImportOptions): Batch size, dry-run and upsert settings applied to each file.
+        type_hints (None | dict): Column types for CSV files, as for import_csv_file.
+
+    Returns:
+        DirectoryReport: What each file imported or why it failed.
+
+    Raises:
+        ValueError: If directory isn't a directory.
+    """
+    if not os.path.isdir(directory):
+        raise ValueError(f"{directory} is not a directory")
+
+    logger = get_logger()
+    report: DirectoryReport = DirectoryReport()
+    paths: list = sorted(path for path in glob.glob(os.path.join(glob.escape(directory), pattern))
+                         if os.path.isfile(path))
+    if not paths:
+        logger.warning("no files in %s match %s", directory, pattern)
+
+    for path in paths:
+        name: str = os.path.basename(path)
+        result: FileImport = report.files.setdefault(name, FileImport())
+        try:
+            if name.lower().endswith(".csv"):
+                result.report, result.warnings = import_csv_file(collection, path, type_hints, opts)
+            else:
+                result.report = import_json_file(collection, path, opts)
+        except BatchInsertError as e:
+            result.report = ImportReport(inserted=e.inserted, replaced=e.replaced)
+            result.error = f"{path}: {e}"
+        except (OSError, ValueError) as e:
+            # parse errors already name the file and line
+            result.error = str(e)
+
+    return report
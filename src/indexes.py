@@ -0,0 +1,110 @@
+from dataclasses import dataclass, field
+
+from bson import json_util
+from pymongo import IndexModel
+from pymongo.collection import Collection
+
+from logs import get_logger
+
+
+@dataclass
+class IndexSpec:
+    """
+    A declarative description of an index to create.
+
+    Attributes:
+        keys (list): (field, direction) pairs; direction is 1, -1 or a type such as "text" or "2dsphere".
+        unique (bool): Whether the index enforces unique keys.
+        name (str): The index name; empty lets the server derive one from the keys.
+    """
+    keys: list = field(default_factory=list)
+    unique: bool = False
+    name: str = ""
+
+    def model(self) -> IndexModel:
+        """
+        Converts the spec into the driver's IndexModel.
+        """
+        options: dict = {"unique": self.unique}
+        if self.name:
+            options["name"] = self.name
+        return IndexModel(self.keys, **options)
+
+    def default_name(self) -> str:
+        """
+        The name the server gives an index with these keys when none is set, e.g. borough_1_name_-1.
+        """
+        return "_".join(f"{key}_{direction}" for key, direction in self.keys)
+
+
+def parse_index_specs_json(s: str) -> list:
+    """
+    Parses a JSON array of index specs such as '[{"keys": {"borough": 1}, "unique": false}]'.
+
+    Args:
+        s (str): The specs; each needs a non-empty keys object, and may set unique and name.
+
+    Returns:
+        list: The parsed IndexSpecs.
+
+    Raises:
+        ValueError: If the JSON is malformed or a spec is missing its keys.
+    """
+    try:
+        raw = json_util.loads(s)
+    except ValueError as e:
+        raise ValueError(f"invalid index spec JSON: {e}") from e
+
+    if isinstance(raw, dict):
+        raw = [raw]
+    if not isinstance(raw, list):
+        raise ValueError(f"index specs must be a JSON array, got {type(raw).__name__}")
+
+    specs: list = []
+    for i, item in enumerate(raw):
+        if not isinstance(item, dict):
+            raise ValueError(f"index spec {i} must be an object, got {type(item).__name__}")
+        unknown: set = set(item) - {"keys", "unique", "name"}
+        if unknown:
+            raise ValueError(f"index spec {i} has unknown keys {sorted(unknown)}")
+
+        keys = item.get("keys")
+        if not isinstance(keys, dict) or not keys:
+            raise ValueError(f"index spec {i} needs a non-empty keys object")
+
+        specs.append(IndexSpec(keys=list(keys.items()), unique=bool(item.get("unique", False)), name=item.get("name", "")))
+
+    return specs
+
+
+def ensure_indexes(collection: Collection, specs: list) -> list:
+    """
+    Creates the indexes described by specs, skipping any that already exist as specified.
+
+    An existing index matches when it has the same name, keys and uniqueness. An
+    index with the same name but different keys or options is left for the server
+    to reject, since silently replacing it could slow other queries.
+
+    Args:
+        collection (Collection): The collection to index.
+        specs (list): The IndexSpecs to ensure.
+
+    Returns:
+        list: The names of the indexes that were created.
+    """
+    existing: dict = collection.index_information()
+
+    missing: list = []
+    for spec in specs:
+        info = existing.get(spec.name or spec.default_name())
+        if info is not None and list(info["key"]) == spec.keys and bool(info.get("unique", False)) == spec.unique:
+            get_logger().info("index %s on %s already exists", spec.name or spec.default_name(), collection.name)
+            continue
+        missing.append(spec)
+
+    if not missing:
+        return []
+
+    created: list = collection.create_indexes([spec.model() for spec in missing])
+    get_logger().info("created indexes %s on %s", ", ".join(created), collection.name)
+    return created
@@ -0,0 +1,99 @@
+import time
+from typing import Optional
+
+from logs import get_logger
+
+
+# the prefix every metric name starts with, so they're easy to find among others scraped
+NAMESPACE: str = "mongoviz"
+
+# latency buckets in seconds, from an indexed lookup up to a collection scan
+LATENCY_BUCKETS: tuple = (0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0, 30.0)
+
+
+class Metrics:
+    """
+    The Prometheus metrics the dashboard server records, labelled by collection.
+
+    Each Metrics has a registry of its own rather than the process-wide default, so
+    only the dashboard's figures are exported.
+
+    Attributes:
+        registry (CollectorRegistry): The registry the metrics are exported from.
+        queries (Counter): Queries answered.
+        documents (Counter): Documents read from MongoDB to answer queries.
+        errors (Counter): Queries MongoDB failed.
+        latency (Histogram): How long queries took, in seconds.
+        cache_hits (Counter): Queries answered from the cache without asking MongoDB.
+    """
+
+    def __init__(self) -> None:
+        """
+        Constructs a new Metrics with its own registry.
+
+        Raises:
+            ImportError: If prometheus_client isn't installed.
+        """
+        from prometheus_client import CollectorRegistry, Counter, Histogram
+
+        self.registry = CollectorRegistry()
+        self.queries = Counter("queries", "Queries answered.", ["collection"],
+                               namespace=NAMESPACE, registry=self.registry)
+        self.documents = Counter("documents_scanned", "Documents read from MongoDB to answer queries.", ["collection"],
+                                 namespace=NAMESPACE, registry=self.registry)
+        self.errors = Counter("query_errors", "Queries MongoDB failed.", ["collection"],
+                              namespace=NAMESPACE, registry=self.registry)
+        self.latency = Histogram("query_duration_seconds", "How long queries took.", ["collection"],
+                                 namespace=NAMESPACE, buckets=LATENCY_BUCKETS, registry=self.registry)
+        self.cache_hits = Counter("cache_hits", "Queries answered from the cache without asking MongoDB.", ["collection"],
+                                  namespace=NAMESPACE, registry=self.registry)
+
+    def time_query(self, collection: str, fn, count=len):
+        """
+        Runs a query and records its latency, the documents it read, or that it failed.
+
+        Args:
+            collection (str): The collection queried, used as the metrics' label.
+            fn (callable): Runs the query and returns its result.
+            count (callable): Says how many documents a result holds.
+
+        Returns:
+            What fn returns.
+        """
+        start: float = time.perf_counter()
+        try:
+            result = fn()
+        except Exception:
+            self.errors.labels(collection).inc()
+            raise
+        finally:
+            self.latency.labels(collection).observe(time.perf_counter() - start)
+
+        self.queries.labels(collection).inc()
+        self.documents.labels(collection).inc(count(result))
+        return result
+
+    def exposition(self) -> tuple:
+        """
+        Renders every metric in the Prometheus text format.
+
+        Returns:
+            tuple: The Content-Type to serve it with and the body.
+        """
+        from prometheus_client import CONTENT_TYPE_LATEST, generate_latest
+
+        return CONTENT_TYPE_LATEST, generate_latest(self.registry).decode("utf-8")
+
+
+def load_metrics() -> Optional[Metrics]:
+    """
+    Sets up the dashboard's metrics, if prometheus_client is installed.
+
+    Returns:
+        None | Metrics: The metrics, or None with a warning logged when the library is missing.
+    """
+    try:
+        return Metrics()
+    except ImportError:
+        get_logger().warning("prometheus_client isn't installed, so /metrics is disabled (pip install prometheus-client)")
+        return None
@@ -0,0 +1,46 @@
+from dataclasses import dataclass, field
+
+
+@dataclass
+class Address:
+    """
+    The address of a restaurant in the sample dataset.
+
+    Attributes:
+        building (str): The building number.
+        street (str): The street name.
+        zipcode (str): The zip code.
+        coord (list): The [longitude, latitude] of the building.
+    """
+    building: str = ""
+    street: str = ""
+    zipcode: str = ""
+    coord: list = field(default_factory=list)
+
+
+@dataclass
+class Restaurant:
+    """
+    A restaurant document from data/restaurants.json, for use with query.find_typed.
+
+    Attributes:
+        name (str): The restaurant name.
+        borough (str): The NYC borough it is in.
+        cuisine (str): The cuisine it serves.
+        restaurant_id (str): The dataset's own identifier.
+        address (dict): The raw address subdocument; see Restaurant.location.
+        grades (list): The inspection grades, most recent first.
+    """
+    name: str
+    borough: str = ""
+    cuisine: str = ""
+    restaurant_id: str = ""
+    address: dict = field(default_factory=dict)
+    grades: list = field(default_factory=list)
+
+    @property
+    def location(self) -> Address:
+        """
+        The address decoded into an Address.
+        """
+        return Address(**{key: value for key, value in self.address.items() if key in Address.__dataclass_fields__})
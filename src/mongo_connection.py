@@ -4,6 +4,198 @@ import plotly.express as px
 import plotly.io as pio
 import pandas as pd
 
+from dataclasses import dataclass
+from typing import Optional
+
+from errors import AUTHENTICATION_FAILED, AuthFailedError, MongoVizError
+from importers import import_documents
+from logs import get_logger, redact_uri
+from proxy import ProxyConfig, ProxyError, check_proxy
+
+
+class MongoConnectionError(MongoVizError):
+    """
+    Raised when a MongoDB server cannot be reached within the connection timeout.
+    """
+
+
+class MongoAuthError(MongoConnectionError, AuthFailedError):
+    """
+    Raised when the server was reached but rejected the supplied credentials.
+    """
+
+
+def connect(uri: str, timeout: float = 10.0, verify: bool = True, proxy: Optional[ProxyConfig] = None,
+            **options) -> pymongo.MongoClient:
+    """
+    Creates a client for the given MongoDB URI and verifies the server is reachable.
+
+    Args:
+        uri (str): The MongoDB connection string, e.g. mongodb://localhost:27017 or mongodb+srv://cluster.example.net.
+        timeout (float): Seconds to wait for the server before giving up.
+        verify (bool): Ping the server now. When False nothing is sent until the first operation.
+        proxy (None | ProxyConfig): A SOCKS5 proxy to make every connection through.
+        **options: Extra MongoClient options such as username, password, authSource or tls.
+
+    Returns:
+        pymongo.MongoClient: A client connected to a server that answered a ping.
+
+    Raises:
+        ProxyError: If the proxy can't be reached, so the server was never tried.
+        MongoAuthError: If the server rejects the credentials.
+        MongoConnectionError: If the server does not respond within the timeout, or an SRV URI doesn't resolve.
+    """
+    via: str = ""
+    if proxy is not None:
+        if verify:
+            check_proxy(proxy, timeout)
+        options.update(proxy.client_options())
+        via = f" through SOCKS5 proxy {proxy.host}:{proxy.port}"
+
+    # the uri goes into log lines and error messages, which mustn't carry its password
+    shown: str = redact_uri(uri)
+    timeout_ms: int = int(timeout * 1000)
+    try:
+        client: pymongo.MongoClient = pymongo.MongoClient(uri,
+                                                          serverSelectionTimeoutMS=timeout_ms,
+                                                          connectTimeoutMS=timeout_ms,
+                                                          connect=verify,
+                                                          **options)
+    except pymongo.errors.ConfigurationError as e:
+        if proxy is not None and "proxy" in str(e).lower():
+            raise ProxyError(f"this PyMongo can't connect through a SOCKS5 proxy, upgrade it to use -proxy: {e}") from e
+        # a mongodb+srv:// URI is resolved here, so a DNS failure or missing dnspython surfaces now
+        raise MongoConnectionError(f"failed to connect to MongoDB at {shown}{via}: {e}") from e
+    if not verify:
+        return client
+
+    try:
+        # MongoClient connects lazily, so ping to confirm the server is actually there
+        client.admin.command("ping")
+    except pymongo.errors.OperationFailure as e:
+        client.close()
+        if e.code == AUTHENTICATION_FAILED:
+            raise MongoAuthError(f"authentication failed for MongoDB at {shown}{via}: {e}") from e
+        raise MongoConnectionError(f"failed to connect to MongoDB at {shown}{via}: {e}") from e
+    except pymongo.errors.PyMongoError as e:
+        client.close()
+        raise MongoConnectionError(f"failed to connect to MongoDB at {shown}{via}: {e}") from e
+
+    get_logger().info("connected to MongoDB at %s%s", shown, via)
+    return client
+
+
+def list_databases(client: pymongo.MongoClient) -> list:
+    """
+    Lists the names of the databases on the server, sorted.
+
+    Args:
+        client (pymongo.MongoClient): A connected client.
+    """
+    return sorted(client.list_database_names())
+
+
+def list_collections(client: pymongo.MongoClient, db_name: str) -> list:
+    """
+    Lists the names of the collections in a database, sorted.
+
+    Args:
+        client (pymongo.MongoClient): A connected client.
+        db_name (str): The database to list.
+    """
+    return sorted(client[db_name].list_collection_names())
+
+
+def print_database_tree(client: pymongo.MongoClient) -> None:
+    """
+    Prints every database on the server with its collections indented beneath it.
+
+    Args:
+        client (pymongo.MongoClient): A connected client.
+    """
+    for db_name in list_databases(client):
+        print(db_name)
+        for collection_name in list_collections(client, db_name):
+            print(f"  {collection_name}")
+
+
+@dataclass
+class CollStat:
+    """
+    The size of one collection in a database, from collStats.
+
+    Attributes:
+        name (str): The collection's name.
+        count (int): The number of documents.
+        storage_size (int): Bytes allocated to the collection's documents on disk.
+        index_size (int): Bytes taken by all of its indexes.
+        view (bool): Whether this is a view, which stores nothing and so has no sizes.
+    """
+    name: str
+    count: int = 0
+    storage_size: int = 0
+    index_size: int = 0
+    view: bool = False
+
+
+def collection_stats(client: pymongo.MongoClient, db_name: str) -> list:
+    """
+    Measures every collection in a database, largest first.
+
+    Args:
+        client (pymongo.MongoClient): A connected client.
+        db_name (str): The database to measure.
+
+    Returns:
+        list: A CollStat per collection by storage size descending, with views at the end by name.
+    """
+    db = client[db_name]
+    stats: list = []
+    for info in db.list_collections():
+        name: str = info["name"]
+        # collStats fails on a view, and a view's documents belong to the collection it reads
+        if info.get("type") == "view":
+            stats.append(CollStat(name=name, view=True))
+            continue
+        result: dict = db.command("collStats", name)
+        stats.append(CollStat(name=name, count=result.get("count", 0), storage_size=result.get("storageSize", 0),
+                              index_size=result.get("totalIndexSize", 0)))
+
+    return sorted(stats, key=lambda stat: (stat.view, -stat.storage_size, stat.name))
+
+
+def format_bytes(n: int) -> str:
+    """
+    Renders a byte count with a binary unit, e.g. 1.5 MiB.
+
+    Args:
+        n (int): The number of bytes.
+    """
+    size: float = float(n)
+    for unit in ("B", "KiB", "MiB", "GiB", "TiB"):
+        if size < 1024 or unit == "TiB":
+            return f"{size:.0f} {unit}" if unit == "B" else f"{size:.1f} {unit}"
+        size /= 1024
+
+
+def print_collection_stats(stats: list) -> None:
+    """
+    Prints collection sizes as an aligned table.
+
+    Args:
+        stats (list): CollStats, e.g. from collection_stats.
+    """
+    rows: list = [("collection", "documents", "storage", "indexes")]
+    for stat in stats:
+        if stat.view:
+            rows.append((stat.name, "(view)", "", ""))
+        else:
+            rows.append((stat.name, str(stat.count), format_bytes(stat.storage_size), format_bytes(stat.index_size)))
+
+    widths: list = [max(len(row[i]) for row in rows) for i in range(4)]
+    for row in rows:
+        print("  ".join([row[0].ljust(widths[0])] + [cell.rjust(width) for cell, width in zip(row[1:], widths[1:])]).rstrip())
+
 
 class MongoDriver():
     """
@@ -13,10 +205,13 @@ class MongoDriver():
         host (str): The hostname of the MongoDB server.
         port (int): The port number of the MongoDB server.
         db_name (str): The name of the MongoDB database.
+        uri (str): The connection string used to reach the server.
+        timeout (float): Seconds to wait for the server when connecting.
+        options (dict): Extra MongoClient options, such as credentials or TLS settings.
 
     """
 
-    def __init__(self, host: str, port: int, db_name: str) -> None:
+    def __init__(self, host: str, port: int, db_name: str, timeout: float = 10.0, **options) -> None:
         """
         Constructs a new MongoConnector object.
 
@@ -24,24 +219,49 @@ class MongoDriver():
             host (str): The hostname of the MongoDB server.
             port (int): The port number of the MongoDB server.
             db_name (str): The name of the MongoDB database.
+            timeout (float): Seconds to wait for the server when connecting.
+            **options: Extra MongoClient options, such as credentials or TLS settings.
 
         """
         self.host: str = host
         self.port: int = port
         self.db_name: str = db_name
+        self.timeout: float = timeout
+        self.uri: str = f"mongodb://{host}:{port}"
+        self.options: dict = options
         self.client = None
         self.db = None
 
-    def connect(self) -> None:
+    @classmethod
+    def from_uri(cls, uri: str, db_name: str, timeout: float = 10.0, **options) -> "MongoDriver":
+        """
+        Constructs a new MongoDriver object from a full connection string.
+
+        Args:
+            uri (str): The MongoDB connection string.
+            db_name (str): The name of the MongoDB database.
+            timeout (float): Seconds to wait for the server when connecting.
+            **options: Extra MongoClient options, such as credentials or TLS settings.
+        """
+        # the connection string may list several hosts, so keep it whole rather than splitting out one
+        driver: MongoDriver = cls(uri, None, db_name, timeout, **options)
+        driver.uri = uri
+        return driver
+
+    def connect(self, verify: bool = True) -> None:
         """
         Connects to the MongoDB server.
+
+        Args:
+            verify (bool): Ping the server now. When False nothing is sent until the first operation.
+
+        Raises:
+            MongoAuthError: If the server rejects the credentials.
+            MongoConnectionError: If the server does not respond within the timeout.
         """
-        try:
-            self.client: pymongo.MongoClient = pymongo.MongoClient(host=self.host, port=self.port)
-            self.db = self.client[self.db_name]
-            print(self.db)
-        except pymongo.errors.ConnectionFailure as e:
-            print(f"Failed to connect to MongoDB server: {e}")
+        self.client: pymongo.MongoClient = connect(self.uri, self.timeout, verify, **self.options)
+        self.db = self.client[self.db_name]
+        get_logger().debug("using database %s", self.db_name)
 
     def disconnect(self) -> None:
         """
@@ -60,7 +280,7 @@ class MongoDriver():
 
         # Delete all documents in the collection
         result = collection.delete_many({})
-        print(f"Deleted {result.deleted_count} documents from {collection.name}")
+        get_logger().info("deleted %d documents from %s", result.deleted_count, collection.name)
 
     def remove_collection(self, collection_name: str) -> None:
         """
@@ -73,7 +293,7 @@ class MongoDriver():
 
         # Drop the collection
         collection.drop()
-        print(f"Dropped {collection.name} from {self.db.name}")
+        get_logger().info("dropped %s from %s", collection.name, self.db.name)
     
     def create_collection(self, collection_name: str) -> None:
         """
@@ -83,16 +303,16 @@ class MongoDriver():
             collection_name (str): The collection name to create.
         """
         self.db.create_collection(collection_name)
-        print(f"Created collecton {collection_name} in {self.db.name}")
+        get_logger().info("created collection %s in %s", collection_name, self.db.name)
     
     def collection_size(self, collection_name: str) -> int:
         """
         Checks the size of a given collection on the established database on the MongoDB server.
 
         Args:
-            collection_name (str): The collection name to create.
+            collection_name (str): The collection name to check.
         """
-        return len(list(self.db[collection_name].find({})))
+        return self.db[collection_name].count_documents({})
 
     def insert_data(self, collection_name: str, json_file: str, clear=False) -> None:
         """
@@ -105,7 +325,7 @@ class MongoDriver():
         """
         try:
             collection = self.db.create_collection(collection_name)
-        except:
+        except pymongo.errors.CollectionInvalid:
             collection = self.db[collection_name]
 
         if clear:
@@ -124,9 +344,9 @@ class MongoDriver():
             # Load the JSON data as a Python object
             data = json.loads(json_data)
 
-            collection.insert_many(list(data))
+            report = import_documents(collection, list(data))
 
-        print(f"{len(data)} documents inserted into collection {collection_name} in the {self.db.name} database.")
+        get_logger().info("%s into collection %s in the %s database", report.summary(), collection_name, self.db.name)
     
     def search_query(self, collection_name: str, qu: dict, proj:dict, lim=10, show=False) -> list:
         """
@@ -143,7 +363,7 @@ class MongoDriver():
         # set the collection name, create a new collection if necessary
         try:
             collection = self.db.create_collection(collection_name)
-        except:
+        except pymongo.errors.CollectionInvalid:
             collection = self.db[collection_name]
         
         # execute the find() query with given query, projection, and limit
@@ -173,7 +393,7 @@ class MongoDriver():
         # set the collection name, create a new collection if necessary
         try:
             collection = self.db.create_collection(collection_name)
-        except:
+        except pymongo.errors.CollectionInvalid:
             collection = self.db[collection_name]
 
         # execute the aggregate() query
@@ -1,18 +1,484 @@
-from mongo_connection import MongoDriver
+import dataclasses
+import itertools
+import signal
+import sys
+from typing import Optional
 
+from analysis import (CardinalityEstimate, CrossTab, Stats, bucket_labels, created_over_time, cross_tab, estimate_cardinality,
+                      field_completeness, field_histogram, group_by, numeric_histogram, numeric_stats, print_completeness,
+                      print_cross_tab, time_series, type_breakdown, value_label, word_frequency)
+from bench import benchmark
+from changes import watch
+from charts import (render_bar_chart_svg, render_grouped_bar_chart_svg, render_heatmap_svg, render_line_chart_svg,
+                    render_pie_chart_svg, render_range_chart_svg)
+from compare import DiffResult, diff_collections, print_diff
+from config import Config, parse_args
+from exporters import export_csv, export_extended_json, export_jsonl
+from formats import OutputFormat, RenderOptions, get_format
+from geo import extract_geo_points
+from html_views import render_completeness_html, render_leaflet_map
+from importers import (DirectoryReport, ImportOptions, ImportReport, import_csv_file, import_directory, import_json_file,
+                       import_json_reader)
+from indexes import ensure_indexes
+from logs import configure_cli_logging
+from mongo_connection import MongoDriver, collection_stats, print_collection_stats, print_database_tree
+from query import (QueryOptions, count_documents, delete_documents, distinct_values, explain_pipeline, explain_query, find_cursor,
+                   print_plan, run_pipeline, sample_pipeline)
+from repl import Repl
+from retry import with_retry
+from schema import infer_schema_concurrent, print_schema
+from server import start_server
+from tail import tail
 
-if __name__ == '__main__':
-    mongo: MongoDriver = MongoDriver('localhost', 27017, 'restaurants')
-    mongo.connect()
 
-    if mongo.collection_size("restaurants_collection") == 0:
-        mongo.insert_data('restaurants_collection', 'data/restaurants.json', clear=False)
+# the conventional shell status for a process stopped by SIGINT (128 + 2)
+EXIT_INTERRUPTED: int = 130
+
+
+def raise_interrupt(signum, frame) -> None:
+    """
+    Signal handler that turns SIGTERM into the same KeyboardInterrupt Ctrl-C raises.
+    """
+    raise KeyboardInterrupt
+
+
+def write_documents(fmt: str, docs, opts: Optional[RenderOptions] = None, ejson: Optional[str] = None) -> None:
+    """
+    Writes documents to stdout in a registered output format.
+
+    Args:
+        fmt (str): The format's name, e.g. jsonl or csv; see format_names.
+        docs (iterable): The documents to write.
+        opts (None | RenderOptions): The settings to write them with.
+        ejson (None | str): relaxed or canonical to write an Extended JSON array instead of fmt.
+
+    Raises:
+        ValueError: If fmt isn't registered, or writes a binary file and stdout is a terminal.
+    """
+    if ejson is not None:
+        export_extended_json(sys.stdout, docs, canonical=ejson == "canonical")
+        return
+
+    output: OutputFormat = get_format(fmt)
+    opts = opts or RenderOptions()
+    if not output.binary:
+        output.write(sys.stdout, docs, opts)
+        return
+    if sys.stdout.isatty():
+        raise ValueError(f"-format {fmt} writes a binary file; redirect stdout to one")
+    output.write(sys.stdout.buffer, docs, opts)
+
+
+def preview_documents(docs, n: int) -> tuple:
+    """
+    Takes the first n documents, reading one more to tell whether that was all of them.
+
+    Args:
+        docs (iterable): The documents.
+        n (int): How many to take.
+
+    Returns:
+        tuple: A list of up to n documents and whether more followed.
+    """
+    docs = iter(docs)
+    head: list = list(itertools.islice(docs, n))
+    return head, next(docs, None) is not None
+
+
+def print_coercion(name: str, report: ImportReport) -> None:
+    """
+    Prints to stderr what -coerce converted in each field of an import, so the changes can be audited.
+
+    Args:
+        name (str): What was imported, to label the lines with.
+        report (ImportReport): What the import did.
+    """
+    if report.coercion is None:
+        return
+    for line in report.coercion.lines():
+        print(f"{name}: {line}", file=sys.stderr)
+
+
+def write_results(cfg: Config, docs) -> None:
+    """
+    Writes -filter or -pipeline results in the chosen format, only the first -preview of them if that was given.
+
+    The note saying the output is a preview goes to stderr, so it doesn't end up in
+    a file the output is redirected to.
+
+    Args:
+        cfg (Config): The parsed configuration.
+        docs (iterable): The documents to write.
+    """
+    if not cfg.preview:
+        write_documents(cfg.format, docs, cfg.render_options(), cfg.ejson)
+        return
+
+    head, more = preview_documents(docs, cfg.preview)
+    write_documents(cfg.format, head, cfg.render_options(), cfg.ejson)
+    if more:
+        print(f"preview: showing the first {len(head)} results; run without -preview for all of them", file=sys.stderr)
+    else:
+        print(f"preview: that was every one of the {len(head)} results", file=sys.stderr)
+
+
+def run_command(cfg: Config, mongo: MongoDriver) -> None:
+    """
+    Runs the one-off command selected by the flags against a connected driver.
+
+    Args:
+        cfg (Config): The parsed configuration.
+        mongo (MongoDriver): A connected driver.
+    """
+    def retry(fn):
+        # only for reads that return nothing until they finish, so a retry can't repeat output or writes
+        return with_retry(cfg.retries + 1, fn)
+
+    if cfg.list_namespaces:
+        print_database_tree(mongo.client)
+        return
+
+    if cfg.overview:
+        print_collection_stats(retry(lambda: collection_stats(mongo.client, cfg.database)))
+        return
+
+    if cfg.import_json is not None:
+        opts: ImportOptions = cfg.import_options()
+        if cfg.import_json == "-":
+            report: ImportReport = import_json_reader(mongo.db[cfg.collection], sys.stdin, opts)
+        else:
+            report = import_json_file(mongo.db[cfg.collection], cfg.import_json, opts)
+        if not cfg.dry_run:
+            print(f"{cfg.database}.{cfg.collection}: {report.summary()}.")
+            print_coercion(cfg.import_json, report)
+        if opts.checkpoint is not None:
+            opts.checkpoint.remove()
+        return
+
+    if cfg.import_csv is not None:
+        opts = cfg.import_options()
+        report, warnings = import_csv_file(mongo.db[cfg.collection], cfg.import_csv, cfg.csv_types, opts)
+        for warning in warnings:
+            print(warning, file=sys.stderr)
+        if not cfg.dry_run:
+            print(f"{cfg.database}.{cfg.collection}: {report.summary()}.")
+            print_coercion(cfg.import_csv, report)
+        if opts.checkpoint is not None:
+            opts.checkpoint.remove()
+        return
+
+    if cfg.import_dir is not None:
+        opts = cfg.import_options()
+        directory: DirectoryReport = import_directory(mongo.db[cfg.collection], cfg.import_dir, cfg.pattern,
+                                                      opts, cfg.csv_types)
+        for name, result in directory.files.items():
+            for warning in result.warnings:
+                print(warning, file=sys.stderr)
+            if result.error is not None:
+                print(result.error, file=sys.stderr)
+            elif not cfg.dry_run:
+                print(f"{name}: {result.report.summary()}")
+                print_coercion(name, result.report)
+        if not cfg.dry_run:
+            print(f"{cfg.database}.{cfg.collection}: {directory.summary()}.")
+        if directory.failed:
+            # the checkpoint stays, so a rerun with -resume only retries the files that failed
+            raise ValueError(f"{len(directory.failed)} of {len(directory.files)} files failed: {', '.join(directory.failed)}")
+        if opts.checkpoint is not None:
+            opts.checkpoint.remove()
+        return
+
+    if cfg.ensure_indexes is not None:
+        created: list = ensure_indexes(mongo.db[cfg.collection], cfg.ensure_indexes)
+        print(f"created {len(created)} of {len(cfg.ensure_indexes)} indexes on {cfg.collection}")
+        return
+
+    if cfg.schema:
+        print_schema(retry(lambda: infer_schema_concurrent(mongo.db[cfg.collection], cfg.sample_size, cfg.schema_workers)))
+        return
+
+    if cfg.histogram is not None:
+        buckets: list = retry(lambda: field_histogram(mongo.db[cfg.collection], cfg.histogram, cfg.top))
+        if cfg.format == "pie":
+            # -top leaves buckets out, so take the slices' shares of every document with the field
+            total: int = retry(lambda: count_documents(mongo.db[cfg.collection], {cfg.histogram: {"$exists": True}}))
+            title: str = f"{cfg.histogram} in {cfg.collection}"
+            if cfg.out is None:
+                render_pie_chart_svg(sys.stdout.buffer, buckets, title, cfg.pie_threshold, total)
+            else:
+                with open(cfg.out, "wb") as f:
+                    render_pie_chart_svg(f, buckets, title, cfg.pie_threshold, total)
+            return
+        for bucket, label in zip(buckets, bucket_labels(buckets)):
+            print(f"{bucket.count:>8}  {label}")
+        if cfg.out is not None:
+            with open(cfg.out, "wb") as f:
+                render_bar_chart_svg(f, buckets, title=f"{cfg.histogram} in {cfg.collection}")
+        return
+
+    if cfg.words is not None:
+        buckets = retry(lambda: word_frequency(mongo.db[cfg.collection], cfg.words, cfg.top))
+        if cfg.format == "csv":
+            export_csv(sys.stdout, [{"word": bucket.value, "count": bucket.count} for bucket in buckets], ["word", "count"])
+        else:
+            for bucket in buckets:
+                print(f"{bucket.count:>8}  {bucket.value}")
+        if cfg.out is not None:
+            with open(cfg.out, "wb") as f:
+                render_bar_chart_svg(f, buckets, title=f"words in {cfg.words} of {cfg.collection}")
+        return
+
+    if cfg.group_by is not None:
+        rows: list = retry(lambda: group_by(mongo.db[cfg.collection], cfg.group_by, cfg.aggregation, cfg.top))
+        measure: str = cfg.aggregation.label
+        if cfg.format == "csv":
+            # the columns are named by the dotted fields themselves, so they're flattened rather than looked up as paths
+            export_csv(sys.stdout, [dict(zip(cfg.group_by, row.key), **{measure: row.value}) for row in rows])
+        else:
+            for row in rows:
+                print(f"{row.value:>12g}  {' / '.join(value_label(value) for value in row.key)}")
+        if cfg.out is not None:
+            with open(cfg.out, "wb") as f:
+                render_grouped_bar_chart_svg(f, rows, cfg.group_by, measure,
+                                             title=f"{measure} by {', '.join(cfg.group_by)} in {cfg.collection}")
+        return
+
+    if cfg.crosstab is not None:
+        field_a, field_b = cfg.crosstab
+        table: CrossTab = retry(lambda: cross_tab(mongo.db[cfg.collection], field_a, field_b, cfg.top))
+        print_cross_tab(table, field_a)
+        if cfg.out is not None:
+            with open(cfg.out, "wb") as f:
+                render_heatmap_svg(f, table, title=f"{field_a} by {field_b} in {cfg.collection}")
+        return
+
+    if cfg.completeness is not None:
+        completeness: dict = retry(lambda: field_completeness(mongo.db[cfg.collection], cfg.completeness))
+        print_completeness(completeness)
+        if cfg.out is not None:
+            with open(cfg.out, "w") as f:
+                render_completeness_html(f, completeness, title=f"Field completeness in {cfg.collection}")
+        return
+
+    if cfg.stats is not None:
+        stats: Stats = retry(lambda: numeric_stats(mongo.db[cfg.collection], cfg.stats))
+        print(f"count={stats.count} min={stats.min:g} max={stats.max:g} avg={stats.avg:g} stddev={stats.std_dev:g}")
+        if stats.skipped:
+            print(f"skipped {stats.skipped} documents where {cfg.stats} isn't a number; -types {cfg.stats} shows what they hold",
+                  file=sys.stderr)
+        return
+
+    if cfg.types is not None:
+        for name, count in retry(lambda: type_breakdown(mongo.db[cfg.collection], cfg.types)).items():
+            print(f"{count:>8}  {name}")
+        return
+
+    if cfg.numeric_histogram is not None:
+        ranges: list = retry(lambda: numeric_histogram(mongo.db[cfg.collection], cfg.numeric_histogram, cfg.bins))
+        for bucket in ranges:
+            print(f"{bucket.count:>8}  {bucket.lo:g} - {bucket.hi:g}")
+        if cfg.out is not None:
+            with open(cfg.out, "wb") as f:
+                render_range_chart_svg(f, ranges, title=f"{cfg.numeric_histogram} in {cfg.collection}")
+        return
+
+    if cfg.cardinality is not None:
+        estimate: CardinalityEstimate = retry(lambda: estimate_cardinality(mongo.db[cfg.collection], cfg.cardinality,
+                                                                           cfg.sample_size))
+        if estimate.exact:
+            print(f"{estimate.estimate} distinct values of {cfg.cardinality} among all {estimate.total} documents")
+        else:
+            print(f"~{estimate.estimate} distinct values of {cfg.cardinality} (approximate: {estimate.observed} seen in "
+                  f"{estimate.with_field} sampled documents holding it, of {estimate.total} in the collection)")
+        return
+
+    if cfg.timeseries is not None:
+        points: list = retry(lambda: time_series(mongo.db[cfg.collection], cfg.timeseries, cfg.bucket))
+        for point in points:
+            print(f"{point.time.isoformat()}  {point.count}")
+        if cfg.out is not None:
+            with open(cfg.out, "wb") as f:
+                render_line_chart_svg(f, points, title=f"{cfg.collection} by {cfg.timeseries} per {cfg.bucket}")
+        return
+
+    if cfg.created_over_time:
+        points = retry(lambda: created_over_time(mongo.db[cfg.collection], cfg.bucket))
+        for point in points:
+            print(f"{point.time.isoformat()}  {point.count}")
+        if cfg.out is not None:
+            with open(cfg.out, "wb") as f:
+                render_line_chart_svg(f, points, title=f"{cfg.collection} created per {cfg.bucket}")
+        return
+
+    if cfg.geo_map is not None:
+        points, skipped = extract_geo_points(mongo.db[cfg.collection], cfg.geo_map, cfg.filter)
+        if skipped:
+            print(f"skipped {skipped} documents without a valid GeoJSON Point in {cfg.geo_map}", file=sys.stderr)
+        title: str = f"{cfg.geo_map} in {cfg.collection}"
+        if cfg.out is None:
+            render_leaflet_map(sys.stdout, points, title=title)
+        else:
+            with open(cfg.out, "w") as f:
+                render_leaflet_map(f, points, title=title)
+        return
+
+    if cfg.sample:
+        pipeline: list = sample_pipeline(cfg.sample, cfg.filter, cfg.query_options)
+        if cfg.explain:
+            print_plan(explain_pipeline(mongo.db[cfg.collection], pipeline))
+            return
+        write_results(cfg, run_pipeline(mongo.db[cfg.collection], pipeline))
+        return
+
+    if cfg.pipeline is not None:
+        if cfg.explain:
+            print_plan(explain_pipeline(mongo.db[cfg.collection], cfg.pipeline))
+            return
+        pipeline = cfg.pipeline
+        if cfg.preview:
+            # one more than shown, so the server stops early but the note can still say if there's more
+            pipeline = pipeline + [{"$limit": cfg.preview + 1}]
+        write_results(cfg, run_pipeline(mongo.db[cfg.collection], pipeline))
+        return
+
+    if cfg.watch:
+        def print_change(change: dict) -> None:
+            export_jsonl(sys.stdout, [change])
+            # a reader at the other end of a pipe wants each event as it happens
+            sys.stdout.flush()
+
+        watch(mongo.db[cfg.collection], print_change)
+        return
+
+    if cfg.delete is not None:
+        deleted: int = delete_documents(mongo.db[cfg.collection], cfg.delete, allow_all=cfg.yes)
+        print(f"deleted {deleted} documents from {cfg.collection}")
+        return
+
+    if cfg.distinct is not None:
+        values: list = retry(lambda: distinct_values(mongo.db[cfg.collection], cfg.distinct, cfg.filter))
+        limit: int = cfg.query_options.limit
+        for value in values[:limit] if limit else values:
+            print(value_label(value))
+        if limit and len(values) > limit:
+            print(f"... and {len(values) - limit} more.")
+        return
+
+    if cfg.diff is not None:
+        database, sep, collection = cfg.diff.partition(".")
+        other = mongo.client[database][collection] if sep else mongo.db[cfg.diff]
+        result: DiffResult = retry(lambda: diff_collections(mongo.db[cfg.collection], other, cfg.diff_key))
+        print_diff(result, f"{cfg.database}.{cfg.collection}", f"{other.database.name}.{other.name}")
+        return
+
+    if cfg.count:
+        print(retry(lambda: count_documents(mongo.db[cfg.collection], cfg.filter or {})))
+        return
+
+    if cfg.filter is not None:
+        if cfg.explain:
+            print_plan(explain_query(mongo.db[cfg.collection], cfg.filter, cfg.query_options))
+            return
+        opts: QueryOptions = cfg.query_options
+        if cfg.preview and not 0 < opts.limit <= cfg.preview:
+            opts = dataclasses.replace(opts, limit=cfg.preview + 1)
+        with find_cursor(mongo.db[cfg.collection], cfg.filter, opts) as cursor:
+            write_results(cfg, cursor)
+        return
+
+    if mongo.collection_size(cfg.collection) == 0:
+        mongo.insert_data(cfg.collection, 'data/restaurants.json', clear=False)
         print("\n Query to make a visualization of the distribution of restaurants across different cuisines in the NYC boroughs: \n")
-    res: list = mongo.aggregate_query("restaurants_collection", [{"$group": {"_id": {"borough": "$borough", "cuisine": "$cuisine"}, "count": {"$sum": 1}}},
+    res: list = mongo.aggregate_query(cfg.collection, [{"$group": {"_id": {"borough": "$borough", "cuisine": "$cuisine"}, "count": {"$sum": 1}}},
                                                                     {"$project": {"borough": "$_id.borough", "cuisine": "$_id.cuisine", "count": "$count", "_id": 0}}]
                                                                     , show=False)
     for item in res[:10]:
         print(item)
 
     mongo.plot_query(res, x_var="borough", y_var="count", color_on="cuisine", plot_title="Resturant Count by Cuisine in NYC Boroughs", save_as='../data/mongo_visualization.png')
-    mongo.disconnect()
\ No newline at end of file
+
+
+def run_bench(cfg: Config, mongo: MongoDriver) -> None:
+    """
+    Times the -pipeline, or the -filter find, over repeated runs and prints a key=value summary.
+
+    Args:
+        cfg (Config): The parsed configuration.
+        mongo (MongoDriver): A connected driver, reused by every run.
+    """
+    collection = mongo.db[cfg.collection]
+
+    # every run drains its cursor, since a query isn't finished until the last batch arrives
+    def run_once() -> int:
+        if cfg.pipeline is not None:
+            cursor = collection.aggregate(cfg.pipeline)
+        else:
+            cursor = find_cursor(collection, cfg.filter or {}, cfg.query_options)
+        with cursor:
+            return sum(1 for _ in cursor)
+
+    print(benchmark(run_once, cfg.runs).summary())
+
+
+def run_tail(cfg: Config, mongo: MongoDriver) -> None:
+    """
+    Prints the newest -last documents of the collection, then each new one as it's inserted, as JSON lines.
+
+    Args:
+        cfg (Config): The parsed configuration.
+        mongo (MongoDriver): A connected driver.
+    """
+    def print_document(doc: dict) -> None:
+        export_jsonl(sys.stdout, [doc])
+        sys.stdout.flush()
+
+    tail(mongo.db[cfg.collection], print_document, cfg.last, cfg.poll_interval)
+
+
+def run(cfg: Config) -> None:
+    """
+    Connects to MongoDB and runs the command selected by the configuration.
+
+    Errors are raised rather than printed, so callers decide how to report them.
+
+    Args:
+        cfg (Config): The parsed configuration.
+    """
+    if cfg.command == "serve":
+        start_server(cfg, cfg.addr)
+        return
+
+    mongo: MongoDriver = MongoDriver.from_uri(cfg.uri, cfg.database, cfg.timeout, **cfg.client_options())
+    # a dry run never talks to the server, so don't fail it just because the server is down
+    with_retry(cfg.retries + 1, lambda: mongo.connect(verify=not cfg.dry_run))
+
+    # an interrupt cancels whatever is in flight, but the client is still closed on the way out
+    try:
+        if cfg.command == "bench":
+            run_bench(cfg, mongo)
+        elif cfg.command == "tail":
+            run_tail(cfg, mongo)
+        elif cfg.command == "repl":
+            Repl(mongo.client, cfg.database, cfg.collection, cfg.sample_size, cfg.max_col_width).run()
+        else:
+            run_command(cfg, mongo)
+    finally:
+        mongo.disconnect()
+
+
+if __name__ == '__main__':
+    signal.signal(signal.SIGTERM, raise_interrupt)
+    cfg: Config = parse_args()
+    configure_cli_logging(cfg.verbosity)
+
+    try:
+        run(cfg)
+    except KeyboardInterrupt:
+        # the dashboard and tail run until stopped, so an interrupt there is a normal shutdown
+        if cfg.command in ("serve", "tail"):
+            sys.exit(0)
+        print("interrupted", file=sys.stderr)
+        sys.exit(EXIT_INTERRUPTED)
+    except Exception as e:
+        print(e, file=sys.stderr)
+        sys.exit(1)
@@ -0,0 +1,901 @@
+import dataclasses
+import datetime
+import re
+import time
+from concurrent.futures import FIRST_EXCEPTION, ThreadPoolExecutor, wait
+from dataclasses import dataclass
+from typing import List, Optional, Type, TypeVar
+
+from bson import Decimal128, json_util
+from pymongo.collection import Collection
+from pymongo.database import Database
+
+from errors import CollectionNotFoundError, InvalidFilterError, MongoVizError, translated_errors
+from logs import get_logger
+
+
+T = TypeVar("T")
+
+# plan stages that read from an index rather than scanning the collection
+INDEX_STAGES: tuple = ("IXSCAN", "EXPRESS_IXSCAN", "COUNT_SCAN", "DISTINCT_SCAN", "IDHACK", "EXPRESS_CLUSTERED_IXSCAN")
+
+# the units of a relative -since, e.g. 90m or 2w
+SINCE_UNITS: dict = {
+    "m": datetime.timedelta(minutes=1),
+    "h": datetime.timedelta(hours=1),
+    "d": datetime.timedelta(days=1),
+    "w": datetime.timedelta(weeks=1),
+}
+
+# the keys a query file may set; unknown ones are refused so a typo isn't silently ignored
+QUERY_SPEC_KEYS: tuple = ("filter", "projection", "sort", "limit", "pipeline")
+
+# how many queries query_many runs at once; each holds a pooled connection while it runs
+DEFAULT_QUERY_WORKERS: int = 4
+
+
+class StopStreaming(Exception):
+    """
+    Raised by a stream_documents callback to stop iterating without reporting an error.
+    """
+
+
+@dataclass
+class QueryOptions:
+    """
+    Options controlling which documents a find query returns.
+
+    Attributes:
+        limit (int): The maximum number of documents to return; 0 means no limit.
+        skip (int): The number of matching documents to skip first.
+        sort (None | list): (field, direction) pairs, with direction 1 or -1.
+        projection (None | dict): The fields to return, as a find projection; None returns whole documents.
+    """
+    limit: int = 0
+    skip: int = 0
+    sort: Optional[list] = None
+    projection: Optional[dict] = None
+
+
+class QueryManyError(MongoVizError):
+    """
+    Raised by query_many when queries fail.
+
+    Attributes:
+        errors (dict): The exception each failed query raised, by query name.
+    """
+
+    def __init__(self, errors: dict) -> None:
+        """
+        Constructs a new QueryManyError for the failed queries.
+
+        Args:
+            errors (dict): The exception each failed query raised, by query name.
+        """
+        super().__init__("; ".join(f"query {name!r} failed: {e}" for name, e in sorted(errors.items())))
+        self.errors: dict = errors
+
+
+@dataclass
+class NamedQuery:
+    """
+    One of the queries query_many runs, such as a panel of a dashboard.
+
+    Attributes:
+        name (str): The key the results are stored under.
+        collection (str): The collection to query.
+        filter (dict): The filter documents must match.
+        opts (None | QueryOptions): Limit, skip, sort and projection options.
+        pipeline (None | list): Aggregation stages to run instead of a find; filter and opts are then ignored.
+    """
+    name: str
+    collection: str
+    filter: dict = dataclasses.field(default_factory=dict)
+    opts: Optional[QueryOptions] = None
+    pipeline: Optional[list] = None
+
+
+@dataclass
+class QuerySpec:
+    """
+    A query kept in a file, so it can be shared and re-run; see load_query_spec.
+
+    Attributes:
+        filter (None | dict): The find filter, {} if the file gives neither a filter nor a pipeline.
+        pipeline (None | list): The aggregation stages, if the query is a pipeline instead of a find.
+        opts (QueryOptions): The projection, sort and limit of a find.
+    """
+    filter: Optional[dict] = None
+    pipeline: Optional[list] = None
+    opts: QueryOptions = dataclasses.field(default_factory=QueryOptions)
+
+
+@dataclass
+class QueryPlan:
+    """
+    The parts of a winning query plan that matter when looking for missing indexes.
+
+    Attributes:
+        stage (str): The top stage of the winning plan, e.g. FETCH or COLLSCAN.
+        stages (list): Every stage in the plan, from the top down.
+        indexes (list): The names of the indexes the plan reads.
+    """
+    stage: str
+    stages: list
+    indexes: list
+
+    @property
+    def uses_index(self) -> bool:
+        """
+        Whether any stage of the plan reads from an index.
+        """
+        return bool(self.indexes) or any(stage in INDEX_STAGES for stage in self.stages)
+
+
+@dataclass
+class LookupSpec:
+    """
+    Describes a $lookup join from one collection into another in the same database.
+
+    Attributes:
+        from_collection (str): The collection to join documents from.
+        local_field (str): The field in the source documents to match on.
+        foreign_field (str): The field in from_collection to match against.
+        as_field (str): The array field the matching documents are stored in.
+    """
+    from_collection: str
+    local_field: str
+    foreign_field: str
+    as_field: str
+
+    def stage(self) -> dict:
+        """
+        Builds the $lookup pipeline stage.
+        """
+        return {"$lookup": {
+            "from": self.from_collection,
+            "localField": self.local_field,
+            "foreignField": self.foreign_field,
+            "as": self.as_field,
+        }}
+
+
+def parse_filter_json(s: str) -> dict:
+    """
+    Parses a query filter given as a JSON object string.
+
+    Extended JSON such as {"$date": ...} or {"$oid": ...} is decoded, so filters can match on BSON types.
+
+    Args:
+        s (str): The filter, e.g. '{"borough": "Bronx"}'.
+
+    Returns:
+        dict: The filter document.
+
+    Raises:
+        InvalidFilterError: If the string isn't valid JSON or isn't an object.
+    """
+    try:
+        query_filter = json_util.loads(s)
+    except ValueError as e:
+        raise InvalidFilterError(f"invalid filter JSON: {e}") from e
+
+    if not isinstance(query_filter, dict):
+        raise InvalidFilterError(f"filter must be a JSON object, got {type(query_filter).__name__}")
+
+    return query_filter
+
+
+def parse_pipeline_json(s: str) -> list:
+    """
+    Parses an aggregation pipeline given as a JSON array string.
+
+    Extended JSON such as {"$date": ...} or {"$oid": ...} is decoded, so stages can match on BSON types.
+
+    Args:
+        s (str): The pipeline, e.g. '[{"$match": {"borough": "Bronx"}}, {"$count": "n"}]'.
+
+    Returns:
+        list: The pipeline stages.
+
+    Raises:
+        ValueError: If the string isn't valid JSON or isn't an array of stage objects.
+    """
+    try:
+        pipeline = json_util.loads(s)
+    except ValueError as e:
+        raise ValueError(f"invalid pipeline JSON: {e}") from e
+
+    check_pipeline(pipeline)
+    return pipeline
+
+
+def check_pipeline(pipeline) -> None:
+    """
+    Checks that a decoded pipeline is a list of stage objects.
+
+    Args:
+        pipeline: The decoded JSON.
+
+    Raises:
+        ValueError: If it isn't.
+    """
+    if not isinstance(pipeline, list):
+        raise ValueError(f"pipeline must be a JSON array of stages, got {type(pipeline).__name__}")
+    for i, stage in enumerate(pipeline):
+        if not isinstance(stage, dict):
+            raise ValueError(f"pipeline stage {i} must be an object, got {type(stage).__name__}")
+
+
+def run_pipeline(collection: Collection, pipeline: list) -> list:
+    """
+    Runs an aggregation pipeline and collects every resulting document.
+
+    Args:
+        collection (Collection): The collection to aggregate over.
+        pipeline (list): The aggregation stages.
+
+    Returns:
+        list: The documents produced by the pipeline.
+    """
+    logger = get_logger()
+    for i, stage in enumerate(pipeline):
+        logger.debug("pipeline stage %d: %s", i, json_util.dumps(stage))
+
+    start: float = time.perf_counter()
+    # the context manager closes the server-side cursor even if decoding a batch fails
+    with collection.aggregate(pipeline) as cursor:
+        documents: list = list(cursor)
+    logger.info("aggregate on %s returned %d documents in %.3fs", collection.name, len(documents), time.perf_counter() - start)
+    return documents
+
+
+def sample_pipeline(size: int, query_filter: dict = None, opts: QueryOptions = None) -> list:
+    """
+    Builds a pipeline picking size random documents, from those matching a filter if one is given.
+
+    How $sample picks depends on the collection. As the first stage, asking for
+    under 5% of a collection of over 100 documents, it reads random documents
+    directly, which is fast but can return the same document more than once.
+    Otherwise, including whenever a filter comes first, it scans the matching
+    documents and shuffles them, which takes time and memory on a large collection.
+    Asking for at least as many as match returns all of them, shuffled.
+
+    Args:
+        size (int): How many documents to sample.
+        query_filter (None | dict): The filter the sampled documents must match.
+        opts (None | QueryOptions): A projection and sort applied to the sample; limit and skip must be 0.
+
+    Returns:
+        list: The pipeline stages.
+
+    Raises:
+        ValueError: If size isn't positive, or opts has a limit or skip.
+    """
+    if size <= 0:
+        raise ValueError(f"sample size must be positive, got {size}")
+    opts = opts or QueryOptions()
+    if opts.limit or opts.skip:
+        raise ValueError("a sample can't also have a limit or skip; the sample size is the limit")
+
+    pipeline: list = []
+    if query_filter:
+        pipeline.append({"$match": query_filter})
+    pipeline.append({"$sample": {"size": size}})
+    if opts.sort:
+        pipeline.append({"$sort": dict(opts.sort)})
+    if opts.projection:
+        pipeline.append({"$project": opts.projection})
+    return pipeline
+
+
+def parse_sort(s: str) -> list:
+    """
+    Parses a sort specification such as "borough:1,name:-1".
+
+    The string is split on commas into keys, and each key on a colon into a field
+    name and a direction. The direction must be 1 (ascending) or -1 (descending)
+    and defaults to 1 when the colon is left off.
+
+    Args:
+        s (str): The sort specification.
+
+    Returns:
+        list: (field, direction) pairs in the order given.
+
+    Raises:
+        ValueError: If a key has an empty field name or a direction other than 1 or -1.
+    """
+    sort: list = []
+    for key in s.split(","):
+        key = key.strip()
+        if not key:
+            continue
+
+        field, _, direction = key.partition(":")
+        field = field.strip()
+        direction = direction.strip() or "1"
+        if not field:
+            raise ValueError(f"invalid sort key {key!r}: missing field name")
+        if direction not in ("1", "-1"):
+            raise ValueError(f"invalid sort direction {direction!r} for {field!r}, expected 1 or -1")
+
+        sort.append((field, int(direction)))
+
+    return sort
+
+
+def parse_fields(s: str) -> dict:
+    """
+    Parses a field list such as "name,email,age" into a projection.
+
+    Listed fields are included, along with _id unless "-_id" is listed too. A list
+    made only of "-field" entries excludes those fields and returns the rest.
+
+    Args:
+        s (str): The comma-separated dotted field paths.
+
+    Returns:
+        dict: A find projection mapping each field to 1 or 0.
+
+    Raises:
+        ValueError: If no field is given, or fields other than _id are both included and excluded.
+    """
+    projection: dict = {}
+    for name in s.split(","):
+        name = name.strip()
+        excluded: bool = name.startswith("-")
+        name = name.lstrip("-").strip()
+        if name:
+            projection[name] = 0 if excluded else 1
+
+    if not projection:
+        raise ValueError("no fields given")
+    # the server accepts excluding _id from an inclusion projection, but no other mix
+    included: list = [name for name, keep in projection.items() if keep]
+    excluded_fields: list = [name for name, keep in projection.items() if not keep and name != "_id"]
+    if included and excluded_fields:
+        raise ValueError(f"can't both include and exclude fields ({', '.join(excluded_fields)}); only _id may be excluded "
+                         f"alongside included fields")
+    return projection
+
+
+def load_query_spec(path: str) -> QuerySpec:
+    """
+    Reads a query from a JSON file, e.g. {"filter": {"borough": "Bronx"}, "sort": "name:1", "limit": 10}.
+
+    The file holds either a filter, with optional projection, sort and limit, or a
+    pipeline, which does its own projecting, sorting and limiting in stages. The
+    projection and sort may be objects, or strings written as the -fields and -sort
+    flags take them. Extended JSON is decoded, as in parse_filter_json.
+
+    Args:
+        path (str): Path to the query file.
+
+    Returns:
+        QuerySpec: The query.
+
+    Raises:
+        ValueError: If the file can't be read, isn't a JSON object, has keys outside
+            QUERY_SPEC_KEYS, or gives both a filter and a pipeline.
+    """
+    try:
+        with open(path, encoding="utf-8") as f:
+            data = json_util.loads(f.read())
+    except OSError as e:
+        raise ValueError(f"could not read query file {path}: {e}") from e
+    except ValueError as e:
+        raise ValueError(f"query file {path} is not valid JSON: {e}") from e
+
+    if not isinstance(data, dict):
+        raise ValueError(f"query file {path} must contain an object, got {type(data).__name__}")
+    unknown: list = sorted(set(data) - set(QUERY_SPEC_KEYS))
+    if unknown:
+        raise ValueError(f"query file {path} has unknown keys {', '.join(unknown)}; expected {', '.join(QUERY_SPEC_KEYS)}")
+
+    spec: QuerySpec = QuerySpec()
+    if "pipeline" in data:
+        if "filter" in data:
+            raise ValueError(f"query file {path} has both a filter and a pipeline; give one, with a $match stage "
+                             f"for the filter")
+        given: list = [key for key in ("projection", "sort", "limit") if key in data]
+        if given:
+            raise ValueError(f"query file {path}: a pipeline can't have {', '.join(given)}; use $project, $sort and "
+                             f"$limit stages instead")
+        try:
+            check_pipeline(data["pipeline"])
+        except ValueError as e:
+            raise ValueError(f"query file {path}: {e}") from e
+        spec.pipeline = data["pipeline"]
+        return spec
+
+    spec.filter = data.get("filter", {})
+    if not isinstance(spec.filter, dict):
+        raise InvalidFilterError(f"query file {path}: filter must be an object, got {type(spec.filter).__name__}")
+
+    projection = data.get("projection")
+    if isinstance(projection, str):
+        try:
+            projection = parse_fields(projection)
+        except ValueError as e:
+            raise ValueError(f"query file {path}: projection: {e}") from e
+    elif projection is not None and not isinstance(projection, dict):
+        raise ValueError(f"query file {path}: projection must be an object or a string such as \"name,borough\"")
+    spec.opts.projection = projection
+
+    sort = data.get("sort")
+    if isinstance(sort, str):
+        try:
+            sort = parse_sort(sort)
+        except ValueError as e:
+            raise ValueError(f"query file {path}: {e}") from e
+    elif isinstance(sort, dict):
+        # JSON objects keep their order, which is the order the keys sort by
+        sort = list(sort.items())
+        bad: list = [name for name, direction in sort if direction not in (1, -1) or isinstance(direction, bool)]
+        if bad:
+            raise ValueError(f"query file {path}: sort directions must be 1 or -1, not for {', '.join(bad)}")
+    elif sort is not None:
+        raise ValueError(f"query file {path}: sort must be an object or a string such as \"name:1\"")
+    spec.opts.sort = sort
+
+    limit = data.get("limit", 0)
+    if not isinstance(limit, int) or isinstance(limit, bool) or limit < 0:
+        raise ValueError(f"query file {path}: limit must be a non-negative integer, got {limit!r}")
+    spec.opts.limit = limit
+    return spec
+
+
+def parse_since(s: str, now: Optional[datetime.datetime] = None) -> datetime.datetime:
+    """
+    Parses the start of a time window, either how long before now, such as "7d", or a date such as "2024-01-01".
+
+    A relative time is a whole number of minutes (m), hours (h), days (d) or weeks
+    (w). A date or date-time without an offset is taken as UTC, which is how
+    MongoDB stores dates.
+
+    Args:
+        s (str): The relative time, or an ISO 8601 date or date-time.
+        now (None | datetime.datetime): What a relative time counts back from; defaults to the current time.
+
+    Returns:
+        datetime.datetime: The start of the window, timezone-aware.
+
+    Raises:
+        ValueError: If s is neither.
+    """
+    s = s.strip()
+    match = re.fullmatch(r"(\d+)([mhdw])", s)
+    if match:
+        now = now or datetime.datetime.now(datetime.timezone.utc)
+        return now - int(match[1]) * SINCE_UNITS[match[2]]
+
+    try:
+        since: datetime.datetime = datetime.datetime.fromisoformat(s)
+    except ValueError:
+        raise ValueError(f"invalid time {s!r}, expected a duration such as 30m, 12h, 7d or 2w, "
+                         f"or a date such as 2024-01-01") from None
+    if since.tzinfo is None:
+        since = since.replace(tzinfo=datetime.timezone.utc)
+    return since
+
+
+def merge_filters(*filters: dict) -> dict:
+    """
+    Combines find filters so documents must match every one of them.
+
+    Filters on different fields are merged into one; if two constrain the same
+    field or operator, they are combined with $and instead, since one would
+    otherwise replace the other.
+
+    Args:
+        filters (dict): The filters; empty or None ones are ignored.
+
+    Returns:
+        dict: The combined filter, {} if there were none.
+    """
+    filters = [f for f in filters if f]
+    merged: dict = {}
+    for f in filters:
+        if merged.keys() & f.keys():
+            return {"$and": filters}
+        merged.update(f)
+    return merged
+
+
+def find_cursor(collection: Collection, query_filter: dict, opts: QueryOptions = None):
+    """
+    Opens a find cursor with the given options applied, for callers that iterate it themselves.
+
+    Args:
+        collection (Collection): The collection to query.
+        query_filter (dict): The filter documents must match.
+        opts (None | QueryOptions): Limit, skip, sort and projection options.
+
+    Returns:
+        pymongo.cursor.Cursor: The unread cursor; close it, or use it as a context manager.
+            The query only runs as it is read, so errors, including a filter the server
+            refuses, come from the driver untranslated; wrap the reading in translated_errors.
+    """
+    opts = opts or QueryOptions()
+    return collection.find(query_filter, projection=opts.projection, skip=opts.skip, limit=opts.limit,
+                           sort=opts.sort or None)
+
+
+def query_documents(collection: Collection, query_filter: dict, opts: QueryOptions = None) -> list:
+    """
+    Runs a find query and collects every matching document.
+
+    Args:
+        collection (Collection): The collection to query.
+        query_filter (dict): The filter documents must match.
+        opts (None | QueryOptions): Limit, skip, sort and projection options.
+
+    Returns:
+        list: The matching documents.
+
+    Raises:
+        InvalidFilterError: If the server refuses the filter.
+    """
+    start: float = time.perf_counter()
+    cursor = find_cursor(collection, query_filter, opts)
+    with cursor, translated_errors(collection.name):
+        documents: list = list(cursor)
+    get_logger().info("find on %s returned %d documents in %.3fs", collection.name, len(documents), time.perf_counter() - start)
+    return documents
+
+
+def count_documents(collection: Collection, query_filter: dict) -> int:
+    """
+    Counts the documents matching a filter without fetching any of them.
+
+    An empty filter is answered from the collection's metadata with
+    estimated_document_count, which is much faster but approximate: after an
+    unclean shutdown, or on a sharded cluster with orphaned documents or balancer
+    migrations in progress, it can differ from the true count.
+
+    Args:
+        collection (Collection): The collection to count.
+        query_filter (dict): The filter documents must match.
+
+    Returns:
+        int: The number of matching documents.
+
+    Raises:
+        InvalidFilterError: If the server refuses the filter.
+    """
+    if not query_filter:
+        return collection.estimated_document_count()
+    with translated_errors(collection.name):
+        return collection.count_documents(query_filter)
+
+
+def value_sort_key(value) -> tuple:
+    """
+    Orders values of mixed types: null, numbers, strings, booleans, dates, then everything else.
+
+    Values of one kind compare naturally, so 9 sorts before 10; values that can't be
+    compared directly, such as documents, are ordered by their Extended JSON text.
+
+    Args:
+        value: A value decoded from a MongoDB document.
+    """
+    if value is None:
+        return (0,)
+    # bool is an int, so it has to be checked before numbers
+    if isinstance(value, bool):
+        return (3, value)
+    if isinstance(value, (int, float)):
+        return (1, value)
+    if isinstance(value, Decimal128):
+        return (1, value.to_decimal())
+    if isinstance(value, str):
+        return (2, value)
+    if isinstance(value, datetime.datetime):
+        return (4, value)
+    return (5, json_util.dumps(value))
+
+
+def distinct_values(collection: Collection, field: str, query_filter: dict = None) -> list:
+    """
+    Lists the distinct values of a field, sorted.
+
+    Each element of an array field counts as a value of its own, and the whole result
+    has to fit in a single 16MB server response.
+
+    Args:
+        collection (Collection): The collection to query.
+        field (str): The dotted path of the field.
+        query_filter (None | dict): Restricts which documents are considered.
+
+    Returns:
+        list: The distinct values, ordered by value_sort_key.
+
+    Raises:
+        InvalidFilterError: If the server refuses the filter.
+    """
+    with translated_errors(collection.name):
+        values: list = collection.distinct(field, query_filter or {})
+    return sorted(values, key=value_sort_key)
+
+
+def decode_document(cls: Type[T], document: dict) -> T:
+    """
+    Builds an instance of cls from a document.
+
+    For dataclasses only the fields the class declares are passed, so extra
+    document fields such as _id are ignored unless the class asks for them.
+
+    Args:
+        cls (type): The class to build, usually a dataclass.
+        document (dict): The document to decode.
+
+    Raises:
+        TypeError: If the document lacks a field the class requires.
+    """
+    values: dict = document
+    if dataclasses.is_dataclass(cls):
+        names: set = {f.name for f in dataclasses.fields(cls)}
+        values = {key: value for key, value in document.items() if key in names}
+    try:
+        return cls(**values)
+    except TypeError as e:
+        raise TypeError(f"cannot decode document {document.get('_id', '')} into {cls.__name__}: {e}") from e
+
+
+def find_typed(collection: Collection, cls: Type[T], query_filter: dict, opts: QueryOptions = None) -> List[T]:
+    """
+    Runs a find query and decodes every matching document into cls.
+
+    This gives library callers attribute access that type checkers can follow,
+    while the command line keeps working with plain dicts:
+
+        restaurants: List[Restaurant] = find_typed(coll, Restaurant, {"borough": "Bronx"})
+
+    Args:
+        collection (Collection): The collection to query.
+        cls (type): The class to decode into, usually a dataclass such as models.Restaurant.
+        query_filter (dict): The filter documents must match.
+        opts (None | QueryOptions): Limit, skip, sort and projection options.
+
+    Returns:
+        list: One cls instance per matching document.
+    """
+    return [decode_document(cls, document) for document in query_documents(collection, query_filter, opts)]
+
+
+def stream_documents(collection: Collection, query_filter: dict, fn, opts: QueryOptions = None) -> None:
+    """
+    Calls a function on each matching document as it arrives, without buffering the results.
+
+    Iteration stops at the first exception raised by fn, which is re-raised after the
+    cursor is closed. Raising StopStreaming ends iteration early without an error.
+
+    Args:
+        collection (Collection): The collection to query.
+        query_filter (dict): The filter documents must match.
+        fn (callable): Called with each document in turn.
+        opts (None | QueryOptions): Limit, skip, sort and projection options.
+
+    Raises:
+        InvalidFilterError: If the server refuses the filter.
+    """
+    logger = get_logger()
+    start: float = time.perf_counter()
+    streamed: int = 0
+
+    cursor = find_cursor(collection, query_filter, opts)
+    with cursor, translated_errors(collection.name):
+        try:
+            for document in cursor:
+                logger.debug("streaming document %s", document.get("_id"))
+                fn(document)
+                streamed += 1
+        except StopStreaming:
+            pass
+    logger.info("find on %s streamed %d documents in %.3fs", collection.name, streamed, time.perf_counter() - start)
+
+
+def lookup_join(collection: Collection, spec: LookupSpec, query_filter: dict = None) -> list:
+    """
+    Joins documents from another collection onto each document with $lookup.
+
+    A $lookup against a collection that doesn't exist silently joins nothing, so
+    the foreign collection is checked first.
+
+    Args:
+        collection (Collection): The collection whose documents are joined onto.
+        spec (LookupSpec): Which collection and fields to join on.
+        query_filter (None | dict): Restricts the source documents before joining.
+
+    Returns:
+        list: The source documents, each with the matches stored under spec.as_field.
+
+    Raises:
+        CollectionNotFoundError: If spec.from_collection doesn't exist in the database.
+    """
+    names: list = collection.database.list_collection_names()
+    if spec.from_collection not in names:
+        raise CollectionNotFoundError(f"cannot join from {spec.from_collection!r}: no such collection in "
+                                      f"{collection.database.name} (have: {', '.join(sorted(names)) or 'none'})")
+
+    pipeline: list = []
+    if query_filter:
+        pipeline.append({"$match": query_filter})
+    pipeline.append(spec.stage())
+    return run_pipeline(collection, pipeline)
+
+
+def query_many(db: Database, queries: list, workers: int = DEFAULT_QUERY_WORKERS, fail_fast: bool = True) -> dict:
+    """
+    Runs several named queries at once, e.g. one per collection a dashboard shows.
+
+    A MongoClient, and the Database and Collection objects taken from it, are
+    thread-safe, so every query shares db's client and its connection pool.
+
+    Args:
+        db (Database): The database the queries' collections are in.
+        queries (list): The NamedQuery objects to run.
+        workers (int): How many queries to run at once.
+        fail_fast (bool): Stop at the first failure, cancelling the queries not yet started,
+            rather than running them all and reporting every failure.
+
+    Returns:
+        dict: The list of documents each query returned, by query name, in the order of queries.
+
+    Raises:
+        ValueError: If two queries share a name, or workers isn't positive.
+        QueryManyError: If any query fails; with fail_fast, only those that failed before it stopped are in it.
+    """
+    if workers <= 0:
+        raise ValueError(f"workers must be positive, got {workers}")
+    names: list = [query.name for query in queries]
+    duplicates: set = {name for name in names if names.count(name) > 1}
+    if duplicates:
+        raise ValueError(f"query names must be unique, repeated: {', '.join(sorted(duplicates))}")
+
+    def run(query: NamedQuery) -> list:
+        collection: Collection = db[query.collection]
+        if query.pipeline is not None:
+            with translated_errors(collection.name):
+                return run_pipeline(collection, query.pipeline)
+        return query_documents(collection, query.filter, query.opts)
+
+    results: dict = {}
+    errors: dict = {}
+    with ThreadPoolExecutor(max_workers=workers) as pool:
+        futures: dict = {pool.submit(run, query): query.name for query in queries}
+        pending: set = set(futures)
+        while pending:
+            done, pending = wait(pending, return_when=FIRST_EXCEPTION)
+            for future in done:
+                if future.cancelled():
+                    continue
+                if future.exception() is not None:
+                    errors[futures[future]] = future.exception()
+                else:
+                    results[futures[future]] = future.result()
+            if errors and fail_fast:
+                # a query already running can't be interrupted, so leaving the pool waits for those
+                for future in pending:
+                    future.cancel()
+                break
+
+    if errors:
+        raise QueryManyError(errors)
+    return {name: results[name] for name in names}
+
+
+def delete_documents(collection: Collection, query_filter: dict, allow_all: bool = False) -> int:
+    """
+    Deletes every document matching a filter.
+
+    Args:
+        collection (Collection): The collection to delete from.
+        query_filter (dict): The filter documents must match.
+        allow_all (bool): Must be True for an empty filter, which deletes the whole collection.
+
+    Returns:
+        int: The number of documents deleted.
+
+    Raises:
+        ValueError: If the filter is empty and allow_all wasn't set.
+        InvalidFilterError: If the server refuses the filter.
+    """
+    if not query_filter and not allow_all:
+        raise ValueError(f"refusing to delete every document in {collection.name} without allow_all")
+
+    with translated_errors(collection.name):
+        result = collection.delete_many(query_filter)
+    get_logger().info("deleted %d documents from %s", result.deleted_count, collection.name)
+    return result.deleted_count
+
+
+def summarize_plan(explain: dict) -> QueryPlan:
+    """
+    Pulls the winning plan out of an explain result for a find or an aggregate.
+
+    Args:
+        explain (dict): The explain output returned by the server.
+
+    Returns:
+        QueryPlan: The winning plan's stages and the indexes they use.
+    """
+    planner = explain.get("queryPlanner")
+    if planner is None:
+        # aggregations nest the find-layer plan under their first stage
+        for stage in explain.get("stages", []):
+            if "$cursor" in stage:
+                planner = stage["$cursor"].get("queryPlanner")
+                break
+    planner = planner or {}
+
+    plan: dict = planner.get("winningPlan", {})
+    # servers using the slot-based engine wrap the classic plan in queryPlan
+    plan = plan.get("queryPlan", plan)
+
+    stages: list = []
+    indexes: list = []
+    pending: list = [plan]
+    while pending:
+        node = pending.pop(0)
+        if not isinstance(node, dict):
+            continue
+        if "stage" in node:
+            stages.append(node["stage"])
+        if "indexName" in node:
+            indexes.append(node["indexName"])
+        if "inputStage" in node:
+            pending.append(node["inputStage"])
+        pending.extend(node.get("inputStages", []))
+
+    return QueryPlan(stage=stages[0] if stages else "UNKNOWN", stages=stages, indexes=indexes)
+
+
+def explain_query(collection: Collection, query_filter: dict, opts: QueryOptions = None) -> QueryPlan:
+    """
+    Explains a find query instead of running it.
+
+    Args:
+        collection (Collection): The collection to query.
+        query_filter (dict): The filter documents must match.
+        opts (None | QueryOptions): Limit, skip, sort and projection options.
+
+    Returns:
+        QueryPlan: The winning plan the server would use.
+
+    Raises:
+        InvalidFilterError: If the server refuses the filter.
+    """
+    cursor = find_cursor(collection, query_filter, opts)
+    with translated_errors(collection.name):
+        return summarize_plan(cursor.explain())
+
+
+def explain_pipeline(collection: Collection, pipeline: list) -> QueryPlan:
+    """
+    Explains an aggregation pipeline instead of running it.
+
+    Args:
+        collection (Collection): The collection to aggregate over.
+        pipeline (list): The aggregation stages.
+
+    Returns:
+        QueryPlan: The winning plan the server would use for the pipeline's initial scan.
+    """
+    explain: dict = collection.database.command("aggregate", collection.name, pipeline=pipeline, explain=True)
+    return summarize_plan(explain)
+
+
+def print_plan(plan: QueryPlan) -> None:
+    """
+    Prints the winning plan's top stage and whether it uses an index.
+
+    Args:
+        plan (QueryPlan): The plan to print.
+    """
+    print(f"winning plan: {' <- '.join(plan.stages) or plan.stage}")
+    if plan.uses_index:
+        print(f"index used: yes ({', '.join(plan.indexes) or 'by _id'})")
+    else:
+        print("index used: no, the query scans the whole collection")
@@ -0,0 +1,233 @@
+import json
+import os
+import sys
+
+import pymongo
+
+from errors import MongoVizError
+from exporters import render_text_table
+from query import QueryOptions, parse_filter_json, parse_pipeline_json, parse_sort, query_documents, run_pipeline
+from schema import infer_schema, print_schema
+
+
+# rows printed by a find that doesn't give its own limit, so a stray "find" can't flood the terminal
+DEFAULT_LIMIT: int = 20
+
+HISTORY_FILE: str = os.path.join(os.path.expanduser("~"), ".mongodb_visualization_history")
+
+HELP: str = """commands:
+  find [FILTER] [limit N] [skip N] [sort FIELD:1,...]   print matching documents
+  agg PIPELINE                                          run an aggregation pipeline
+  .schema [SAMPLE]                                      print the inferred schema
+  .use [DB.]COLLECTION                                  switch collection, and database if given
+  .help                                                 show this message
+  .exit                                                 quit (or Ctrl-D)"""
+
+
+def split_json(s: str) -> tuple:
+    """
+    Splits a leading JSON value off the start of a command's arguments.
+
+    Args:
+        s (str): The arguments, e.g. '{"borough": "Bronx"} limit 10'.
+
+    Returns:
+        tuple: The JSON text, empty if s doesn't start with an object or array, and the rest of s.
+
+    Raises:
+        ValueError: If s starts with an object or array that isn't valid JSON.
+    """
+    s = s.strip()
+    if not s.startswith(("{", "[")):
+        return "", s
+    try:
+        _, end = json.JSONDecoder().raw_decode(s)
+    except json.JSONDecodeError as e:
+        raise ValueError(f"invalid JSON: {e}") from e
+    return s[:end], s[end:].strip()
+
+
+def parse_find_options(s: str) -> QueryOptions:
+    """
+    Parses the options after a find's filter, such as "limit 10 skip 5 sort name:1".
+
+    Args:
+        s (str): The options, as keyword and value pairs.
+
+    Returns:
+        QueryOptions: The options, with a limit of DEFAULT_LIMIT if none was given.
+
+    Raises:
+        ValueError: If an option is unknown or its value is missing or invalid.
+    """
+    opts: QueryOptions = QueryOptions(limit=DEFAULT_LIMIT)
+    words: list = s.split()
+    if len(words) % 2:
+        raise ValueError(f"option {words[-1]!r} needs a value")
+
+    for key, value in zip(words[::2], words[1::2]):
+        if key == "sort":
+            opts.sort = parse_sort(value)
+        elif key in ("limit", "skip"):
+            if not value.isdigit():
+                raise ValueError(f"{key} must be a non-negative integer, got {value!r}")
+            setattr(opts, key, int(value))
+        else:
+            raise ValueError(f"unknown option {key!r}, expected limit, skip or sort")
+    return opts
+
+
+class Repl:
+    """
+    An interactive prompt that runs queries over one open client.
+
+    Attributes:
+        client (pymongo.MongoClient): The client every command runs through.
+        database (str): The database commands run against.
+        collection (str): The collection commands run against.
+        sample_size (int): How many documents .schema samples by default.
+        max_col_width (int): The widest a table column gets; 0 means no limit.
+    """
+
+    def __init__(self, client: pymongo.MongoClient, database: str, collection: str, sample_size: int,
+                 max_col_width: int = 0) -> None:
+        """
+        Constructs a new Repl.
+
+        Args:
+            client (pymongo.MongoClient): A connected client.
+            database (str): The database to start in.
+            collection (str): The collection to start in.
+            sample_size (int): How many documents .schema samples by default.
+            max_col_width (int): The widest a table column gets; 0 means no limit.
+        """
+        self.client: pymongo.MongoClient = client
+        self.database: str = database
+        self.collection: str = collection
+        self.sample_size: int = sample_size
+        self.max_col_width: int = max_col_width
+
+    @property
+    def prompt(self) -> str:
+        """
+        The prompt, naming the current namespace.
+        """
+        return f"{self.database}.{self.collection}> "
+
+    def handle(self, line: str) -> bool:
+        """
+        Runs one command line.
+
+        Args:
+            line (str): The line as typed.
+
+        Returns:
+            bool: False if the command asks to quit.
+
+        Raises:
+            ValueError: If the command or its arguments are invalid.
+            pymongo.errors.PyMongoError: If the server rejects the command.
+        """
+        command, _, args = line.strip().partition(" ")
+        coll = self.client[self.database][self.collection]
+
+        if not command:
+            return True
+        if command in (".exit", ".quit"):
+            return False
+        if command == ".help":
+            print(HELP)
+        elif command == ".use":
+            self.use(args.strip())
+        elif command == ".schema":
+            if args.strip() and not args.strip().isdigit():
+                raise ValueError(f".schema takes a sample size, got {args.strip()!r}")
+            print_schema(infer_schema(coll, int(args) if args.strip() else self.sample_size))
+        elif command == "find":
+            filter_text, rest = split_json(args)
+            query_filter: dict = parse_filter_json(filter_text) if filter_text else {}
+            opts: QueryOptions = parse_find_options(rest)
+            docs: list = query_documents(coll, query_filter, opts)
+            self.print_documents(docs)
+            if opts.limit and len(docs) == opts.limit:
+                print(f"(showing the first {opts.limit}; add limit N for more)")
+        elif command == "agg":
+            pipeline_text, rest = split_json(args)
+            if not pipeline_text or rest:
+                raise ValueError("agg takes a single JSON array of stages")
+            self.print_documents(run_pipeline(coll, parse_pipeline_json(pipeline_text)))
+        else:
+            raise ValueError(f"unknown command {command!r}; try .help")
+        return True
+
+    def use(self, namespace: str) -> None:
+        """
+        Switches to another collection, and database if the namespace names one.
+
+        Args:
+            namespace (str): A collection name, or database.collection.
+        """
+        if not namespace:
+            raise ValueError(".use needs a collection or database.collection")
+        database, sep, collection = namespace.partition(".")
+        if sep:
+            if not database or not collection:
+                raise ValueError(f"invalid namespace {namespace!r}, expected database.collection")
+            self.database, self.collection = database, collection
+        else:
+            self.collection = namespace
+
+    def print_documents(self, docs: list) -> None:
+        """
+        Prints documents as a table, or a note that there were none.
+
+        Args:
+            docs (list): The documents to print.
+        """
+        if not docs:
+            print("(no documents)")
+            return
+        render_text_table(sys.stdout, docs, max_col_width=self.max_col_width)
+
+    def run(self) -> None:
+        """
+        Reads and runs commands until .exit or end of input.
+
+        Errors are printed and the prompt carries on. Ctrl-C abandons the current line.
+        """
+        try:
+            # line editing and history where the platform has readline; plain input() otherwise
+            import readline
+        except ImportError:
+            readline = None
+        if readline is not None:
+            try:
+                readline.read_history_file(HISTORY_FILE)
+            except OSError:
+                pass
+
+        print("type .help for commands, Ctrl-D to quit")
+        try:
+            while True:
+                try:
+                    line: str = input(self.prompt)
+                except EOFError:
+                    print()
+                    return
+                except KeyboardInterrupt:
+                    print()
+                    continue
+
+                try:
+                    if not self.handle(line):
+                        return
+                except (ValueError, MongoVizError, pymongo.errors.PyMongoError) as e:
+                    print(f"error: {e}")
+                except KeyboardInterrupt:
+                    print("interrupted")
+        finally:
+            if readline is not None:
+                try:
+                    readline.write_history_file(HISTORY_FILE)
+                except OSError:
+                    pass
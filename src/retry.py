@@ -0,0 +1,86 @@
+import random
+import time
+
+import pymongo
+
+from logs import get_logger
+from errors import AUTHENTICATION_FAILED
+
+
+# retries after the first attempt when -retries isn't given
+DEFAULT_RETRIES: int = 2
+
+# the first backoff, doubled on every further attempt up to MAX_DELAY
+BASE_DELAY: float = 0.5
+MAX_DELAY: float = 8.0
+
+# errors that say the network or the topology is having a moment, not that the request is wrong
+TRANSIENT_ERRORS: tuple = (
+    pymongo.errors.ConnectionFailure,  # includes AutoReconnect, NotPrimaryError and ServerSelectionTimeoutError
+    pymongo.errors.ExecutionTimeout,
+    pymongo.errors.WTimeoutError,
+)
+
+
+def is_transient(error: BaseException) -> bool:
+    """
+    Decides whether an error might go away if the operation is tried again.
+
+    Errors are followed through their __cause__, so a MongoConnectionError raised
+    from a server selection timeout counts as transient while one raised from an
+    authentication failure does not.
+
+    Args:
+        error (BaseException): The error an attempt failed with.
+    """
+    transient: bool = False
+    while error is not None:
+        if isinstance(error, pymongo.errors.OperationFailure) and error.code == AUTHENTICATION_FAILED:
+            return False
+        if isinstance(error, TRANSIENT_ERRORS):
+            transient = True
+        error = error.__cause__
+    return transient
+
+
+def backoff_delay(attempt: int) -> float:
+    """
+    Picks how long to wait before the next attempt, with full jitter.
+
+    Args:
+        attempt (int): The number of attempts made so far, starting at 1.
+    """
+    # jitter keeps many clients that failed together from retrying in lockstep
+    return random.uniform(0, min(MAX_DELAY, BASE_DELAY * 2 ** (attempt - 1)))
+
+
+def with_retry(attempts: int, fn, sleep=time.sleep):
+    """
+    Calls fn until it succeeds, retrying transient errors with exponential backoff.
+
+    Errors that aren't transient, such as bad credentials or an invalid query, are
+    raised straight away without using up the remaining attempts.
+
+    Args:
+        attempts (int): The most times to call fn, at least 1.
+        fn (callable): The operation, taking no arguments.
+        sleep (callable): Waits a number of seconds between attempts.
+
+    Returns:
+        Whatever fn returns.
+
+    Raises:
+        Exception: The error from the last attempt, or the first non-transient error.
+    """
+    if attempts < 1:
+        raise ValueError(f"attempts must be at least 1, got {attempts}")
+
+    for attempt in range(1, attempts + 1):
+        try:
+            return fn()
+        except Exception as e:
+            if attempt == attempts or not is_transient(e):
+                raise
+            delay: float = backoff_delay(attempt)
+            get_logger().warning("attempt %d of %d failed: %s; retrying in %.1fs", attempt, attempts, e, delay)
+            sleep(delay)
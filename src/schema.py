@@ -0,0 +1,259 @@
+import datetime
+from concurrent.futures import ThreadPoolExecutor
+from dataclasses import dataclass, field
+from typing import Optional
+
+from bson import Binary, Code, Decimal128, Int64, MaxKey, MinKey, ObjectId, Regex, Timestamp
+from pymongo.collection import Collection
+
+
+# how many documents a schema is inferred from unless told otherwise
+DEFAULT_SAMPLE_SIZE: int = 1000
+
+
+@dataclass
+class FieldStats:
+    """
+    What was observed about one field path across a sample of documents.
+
+    Attributes:
+        path (str): The dotted path of the field, e.g. address.city.
+        types (set): The BSON type names seen for the field.
+        count (int): The number of sampled documents containing the field.
+    """
+    path: str
+    types: set = field(default_factory=set)
+    count: int = 0
+
+
+@dataclass
+class Schema:
+    """
+    The fields observed in a sample of a collection.
+
+    Attributes:
+        documents (int): The number of documents that were sampled.
+        fields (list): FieldStats for every path seen, most frequent first.
+    """
+    documents: int = 0
+    fields: list = field(default_factory=list)
+
+    def get(self, path: str) -> Optional[FieldStats]:
+        """
+        Looks up the stats for a field path.
+
+        Args:
+            path (str): The dotted path of the field.
+        """
+        for stats in self.fields:
+            if stats.path == path:
+                return stats
+        return None
+
+    def paths(self) -> list:
+        """
+        Lists the field paths, most frequent first.
+        """
+        return [stats.path for stats in self.fields]
+
+
+def bson_type(value) -> str:
+    """
+    Names the BSON type a Python value is stored as, using the names $type accepts.
+
+    Args:
+        value: A value decoded from a MongoDB document.
+    """
+    # bool is a subclass of int and Int64 of int, so the order of these checks matters
+    if value is None:
+        return "null"
+    if isinstance(value, bool):
+        return "bool"
+    if isinstance(value, Int64):
+        return "long"
+    if isinstance(value, int):
+        return "int" if -2**31 <= value < 2**31 else "long"
+    if isinstance(value, float):
+        return "double"
+    if isinstance(value, Decimal128):
+        return "decimal"
+    if isinstance(value, str):
+        return "javascript" if isinstance(value, Code) else "string"
+    if isinstance(value, dict):
+        return "object"
+    if isinstance(value, (list, tuple)):
+        return "array"
+    if isinstance(value, ObjectId):
+        return "objectId"
+    if isinstance(value, datetime.datetime):
+        return "date"
+    if isinstance(value, Timestamp):
+        return "timestamp"
+    if isinstance(value, (bytes, Binary)):
+        return "binData"
+    if isinstance(value, Regex):
+        return "regex"
+    if isinstance(value, MinKey):
+        return "minKey"
+    if isinstance(value, MaxKey):
+        return "maxKey"
+    return type(value).__name__
+
+
+def _walk(value, path: str, seen: dict) -> None:
+    """
+    Records the type of a value and of everything nested inside it.
+
+    Args:
+        value: The value found at path.
+        path (str): The dotted path of the value.
+        seen (dict): Maps paths to the set of types seen within the current document.
+    """
+    seen.setdefault(path, set()).add(bson_type(value))
+
+    if isinstance(value, dict):
+        for key, child in value.items():
+            _walk(child, f"{path}.{key}", seen)
+    elif isinstance(value, (list, tuple)):
+        # query paths like grades.score reach into array elements, so embedded
+        # documents share the array's path rather than getting an index
+        for element in value:
+            if isinstance(element, dict):
+                for key, child in element.items():
+                    _walk(child, f"{path}.{key}", seen)
+
+
+def schema_from_documents(docs) -> Schema:
+    """
+    Builds a Schema from documents that have already been fetched or parsed.
+
+    Args:
+        docs (iterable): The documents to walk.
+
+    Returns:
+        Schema: The observed fields, sorted by how many documents contain them.
+    """
+    by_path: dict = {}
+    documents: int = 0
+
+    for doc in docs:
+        documents += 1
+        seen: dict = {}
+        for key, value in doc.items():
+            _walk(value, key, seen)
+
+        # count each path once per document, even if an array repeated it
+        for path, types in seen.items():
+            stats: FieldStats = by_path.setdefault(path, FieldStats(path))
+            stats.types |= types
+            stats.count += 1
+
+    fields: list = sorted(by_path.values(), key=lambda s: (-s.count, s.path))
+    return Schema(documents=documents, fields=fields)
+
+
+def infer_schema(collection: Collection, sample_size: int) -> Schema:
+    """
+    Infers the schema of a collection from a random sample of its documents.
+
+    Args:
+        collection (Collection): The collection to sample.
+        sample_size (int): The maximum number of documents to sample.
+
+    Returns:
+        Schema: The observed fields, sorted by how many sampled documents contain them.
+    """
+    if sample_size <= 0:
+        raise ValueError(f"sample size must be positive, got {sample_size}")
+
+    documents = collection.aggregate([{"$sample": {"size": sample_size}}])
+    return schema_from_documents(documents)
+
+
+def merge_schemas(schemas: list) -> Schema:
+    """
+    Combines schemas inferred from separate sets of documents into one.
+
+    The result doesn't depend on the order of schemas, so partial results can be
+    merged in whatever order they finish.
+
+    Args:
+        schemas (list): The Schemas to combine.
+
+    Returns:
+        Schema: Counts summed and types unioned per path, sorted like schema_from_documents.
+    """
+    by_path: dict = {}
+    documents: int = 0
+
+    for schema in schemas:
+        documents += schema.documents
+        for stats in schema.fields:
+            merged: FieldStats = by_path.setdefault(stats.path, FieldStats(stats.path))
+            merged.types |= stats.types
+            merged.count += stats.count
+
+    fields: list = sorted(by_path.values(), key=lambda s: (-s.count, s.path))
+    return Schema(documents=documents, fields=fields)
+
+
+def infer_schema_concurrent(collection: Collection, sample_size: int, workers: int) -> Schema:
+    """
+    Infers the schema of a collection by sampling separate ranges of it in parallel.
+
+    The collection is split into workers ranges of _id order, and each worker
+    samples its share of sample_size from its own range, so no document is read
+    by two workers and the sample is spread across the whole collection.
+
+    Args:
+        collection (Collection): The collection to sample.
+        sample_size (int): The maximum number of documents to sample in total.
+        workers (int): How many ranges to sample at once.
+
+    Returns:
+        Schema: The observed fields, sorted by how many sampled documents contain them.
+    """
+    if sample_size <= 0:
+        raise ValueError(f"sample size must be positive, got {sample_size}")
+    if workers <= 0:
+        raise ValueError(f"workers must be positive, got {workers}")
+    if workers == 1:
+        return infer_schema(collection, sample_size)
+
+    total: int = collection.estimated_document_count()
+    # $limit refuses 0, which an empty collection's span would be
+    if total == 0:
+        return Schema()
+    span: int = -(-total // workers)
+
+    def sample_range(i: int) -> Schema:
+        # spread the sample evenly, giving any remainder to the first ranges
+        size: int = sample_size // workers + (1 if i < sample_size % workers else 0)
+        # with fewer documents than workers, the last ranges start past the end
+        if size == 0 or i * span >= total:
+            return Schema()
+        pipeline: list = [
+            {"$sort": {"_id": 1}},
+            {"$skip": i * span},
+            {"$limit": span},
+            {"$sample": {"size": size}},
+        ]
+        return schema_from_documents(collection.aggregate(pipeline))
+
+    with ThreadPoolExecutor(max_workers=workers) as pool:
+        partials: list = list(pool.map(sample_range, range(workers)))
+    return merge_schemas(partials)
+
+
+def print_schema(schema: Schema) -> None:
+    """
+    Prints a Schema as an aligned table of paths, presence and types.
+
+    Args:
+        schema (Schema): The schema to print.
+    """
+    width: int = max([len(stats.path) for stats in schema.fields] + [len("field")])
+    print(f"{'field':<{width}}  {'present':>9}  types")
+    for stats in schema.fields:
+        present: str = f"{stats.count}/{schema.documents}"
+        print(f"{stats.path:<{width}}  {present:>9}  {', '.join(sorted(stats.types))}")
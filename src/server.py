@@ -0,0 +1,574 @@
+import contextlib
+import dataclasses
+import io
+import threading
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+from typing import Optional
+from urllib.parse import parse_qs, urlencode, urlparse
+
+import pymongo
+from bson import json_util
+
+from analysis import GRANULARITIES, field_histogram, numeric_stats, time_series
+from cache import Cache, cache_key
+from changes import ChangeStreamsUnsupportedError, follow_changes, open_change_stream
+from config import Config
+from errors import CollectionNotFoundError, InvalidFilterError, NoDocumentsError
+from health import HealthMonitor
+from html_views import render_html_table, render_json_tree
+from logs import get_logger
+from metrics import Metrics, load_metrics
+from mongo_connection import connect
+from query import QueryOptions, parse_filter_json, query_documents
+from retry import with_retry
+from schema import infer_schema
+
+
+# caps how much a single dashboard request can pull back when no limit is given
+DEFAULT_PAGE_SIZE: int = 100
+# the most a request can ask for, since limit=0 would otherwise mean the whole collection
+MAX_PAGE_SIZE: int = 1000
+DEFAULT_SCHEMA_SAMPLE: int = 1000
+
+# endpoints that stream until the browser leaves, so shutdown doesn't wait for them
+STREAMING_ROUTES: tuple = ("/events",)
+
+
+class RequestError(Exception):
+    """
+    Raised by a handler when the request itself is invalid, answered with a 400.
+    """
+
+
+class ShutdownTimeoutError(Exception):
+    """
+    Raised when requests were still running at the end of the shutdown grace period and were cut off.
+    """
+
+
+class DashboardServer(ThreadingHTTPServer):
+    """
+    An HTTP server that shares one MongoDB client across every request it handles.
+
+    Attributes:
+        cfg (Config): The configuration the server was started with.
+        health (HealthMonitor): Hands out the client all handlers query through, replacing it if the connection dies.
+        metrics (None | Metrics): What /metrics exports, or None without prometheus_client.
+        cache (Cache): Recent query results, shared by identical requests.
+        in_flight (int): Requests being handled right now, not counting event streams.
+    """
+
+    # waiting on request threads is done with a deadline in shutdown_gracefully, not unbounded in server_close
+    block_on_close: bool = False
+
+    def __init__(self, address: tuple, cfg: Config, client: pymongo.MongoClient) -> None:
+        """
+        Constructs a new DashboardServer bound to address.
+
+        Args:
+            address (tuple): The (host, port) to listen on.
+            cfg (Config): The configuration naming the database and default collection.
+            client (pymongo.MongoClient): A connected client to share across requests.
+        """
+        super().__init__(address, DashboardHandler)
+        self.cfg: Config = cfg
+        self.health: HealthMonitor = HealthMonitor(client, lambda: connect(cfg.uri, cfg.timeout, **cfg.client_options()),
+                                                   interval=cfg.health_interval)
+        self.metrics: Optional[Metrics] = load_metrics()
+        self.cache: Cache = Cache(cfg.cache_ttl)
+        self.in_flight: int = 0
+        self._idle: threading.Condition = threading.Condition()
+
+    @property
+    def client(self) -> pymongo.MongoClient:
+        """
+        The current client, which may change if the health monitor reconnects.
+        """
+        return self.health.client
+
+    @property
+    def db(self):
+        """
+        The database the dashboard reads from.
+        """
+        return self.client[self.cfg.database]
+
+    @contextlib.contextmanager
+    def tracking(self):
+        """
+        Counts a request as in flight for as long as the with block runs.
+        """
+        with self._idle:
+            self.in_flight += 1
+        try:
+            yield
+        finally:
+            with self._idle:
+                self.in_flight -= 1
+                self._idle.notify_all()
+
+    def shutdown_gracefully(self, grace: float) -> None:
+        """
+        Stops accepting connections and waits for the requests already running to finish.
+
+        Event streams aren't waited for, as they only end when the browser leaves; the
+        page's EventSource reconnects once the dashboard is back.
+
+        Args:
+            grace (float): The most seconds to wait.
+
+        Raises:
+            ShutdownTimeoutError: If requests were still running after grace seconds.
+        """
+        self.socket.close()
+        with self._idle:
+            if not self._idle.wait_for(lambda: self.in_flight == 0, timeout=grace):
+                raise ShutdownTimeoutError(f"{self.in_flight} requests were still running after {grace:g}s "
+                                           f"and were cut off")
+
+
+class DashboardHandler(BaseHTTPRequestHandler):
+    """
+    Serves the read-only dashboard pages and JSON endpoints.
+    """
+
+    server: DashboardServer
+
+    def do_GET(self) -> None:
+        """
+        Routes a GET request to the matching endpoint.
+        """
+        url = urlparse(self.path)
+        params: dict = {key: values[-1] for key, values in parse_qs(url.query).items()}
+
+        routes: dict = {
+            "/": self.handle_index,
+            "/collections": self.handle_collections,
+            "/query": self.handle_query,
+            "/schema": self.handle_schema,
+            "/events": self.handle_events,
+            "/metrics": self.handle_metrics,
+            "/health": self.handle_health,
+            "/api/histogram": self.handle_histogram,
+            "/api/timeseries": self.handle_timeseries,
+            "/api/stats": self.handle_stats,
+        }
+        route = routes.get(url.path)
+        if route is None:
+            self.send_json(404, {"error": f"no such endpoint {url.path}"})
+            return
+
+        try:
+            if url.path in STREAMING_ROUTES:
+                route(params)
+            else:
+                with self.server.tracking():
+                    route(params)
+        except (RequestError, InvalidFilterError) as e:
+            self.send_json(400, {"error": str(e)})
+        except (CollectionNotFoundError, NoDocumentsError) as e:
+            self.send_json(404, {"error": str(e)})
+        except pymongo.errors.PyMongoError as e:
+            self.send_json(500, {"error": str(e)})
+
+    def do_OPTIONS(self) -> None:
+        """
+        Answers a browser's CORS preflight, which it sends before a cross-origin request with custom headers.
+        """
+        self.send_response(204)
+        self.send_cors_headers()
+        self.send_header("Access-Control-Allow-Methods", "GET, OPTIONS")
+        self.send_header("Access-Control-Allow-Headers", self.headers.get("Access-Control-Request-Headers", ""))
+        self.send_header("Access-Control-Max-Age", "86400")
+        self.end_headers()
+
+    def handle_index(self, params: dict) -> None:
+        """
+        Renders the collection as an HTML table, or as collapsible trees with view=tree.
+
+        Args:
+            params (dict): The query string, accepting collection, filter, limit and view.
+        """
+        view: str = params.get("view", "table")
+        if view not in ("table", "tree"):
+            raise RequestError(f"view must be table or tree, got {view!r}")
+        name: str = self.collection_name(params)
+        query_filter: dict = self.query_filter(params)
+        opts: QueryOptions = QueryOptions(limit=self.limit_param(params))
+        docs: list = self.cached_query(cache_key(name, query_filter, opts.projection, opts.sort, opts.limit, opts.skip),
+                                       lambda: query_documents(self.server.db[name], query_filter, opts))
+
+        page = io.StringIO()
+        render = render_json_tree if view == "tree" else render_html_table
+        render(page, docs, title=f"{self.server.cfg.database}.{name}", events_url="/events?" + urlencode({"collection": name}))
+        self.send_body(200, "text/html; charset=utf-8", page.getvalue())
+
+    def handle_collections(self, params: dict) -> None:
+        """
+        Lists the collections in the database.
+
+        Args:
+            params (dict): The query string, unused.
+        """
+        self.send_json(200, sorted(self.server.db.list_collection_names()))
+
+    def handle_query(self, params: dict) -> None:
+        """
+        Returns the documents matching a filter as JSON.
+
+        Args:
+            params (dict): The query string, accepting collection, filter, limit and skip.
+        """
+        name: str = self.collection_name(params)
+        opts: QueryOptions = QueryOptions(limit=self.limit_param(params),
+                                          skip=self.int_param(params, "skip", 0))
+        query_filter: dict = self.query_filter(params)
+        docs: list = self.cached_query(cache_key(name, query_filter, opts.projection, opts.sort, opts.limit, opts.skip),
+                                       lambda: query_documents(self.server.db[name], query_filter, opts))
+        self.send_json(200, docs)
+
+    def handle_schema(self, params: dict) -> None:
+        """
+        Returns the inferred schema of a collection as JSON.
+
+        Args:
+            params (dict): The query string, accepting collection and sample.
+        """
+        name: str = self.collection_name(params)
+        sample: int = self.int_param(params, "sample", DEFAULT_SCHEMA_SAMPLE)
+        if sample <= 0:
+            raise RequestError("sample must be positive")
+
+        schema = self.cached_query((name, "schema", sample), lambda: infer_schema(self.server.db[name], sample),
+                                   count=lambda s: s.documents)
+        self.send_json(200, {
+            "documents": schema.documents,
+            "fields": [{"path": s.path, "types": sorted(s.types), "count": s.count} for s in schema.fields],
+        })
+
+    def handle_histogram(self, params: dict) -> None:
+        """
+        Returns the distinct values of a field and their counts as JSON, as -histogram charts them.
+
+        Args:
+            params (dict): The query string, requiring field and accepting collection and top.
+        """
+        name: str = self.collection_name(params)
+        field: str = self.field_param(params)
+        top: int = self.int_param(params, "top", self.server.cfg.top)
+        buckets: list = self.cached_query((name, "histogram", field, top),
+                                          lambda: field_histogram(self.server.db[name], field, top))
+        self.send_json(200, [dataclasses.asdict(bucket) for bucket in buckets])
+
+    def handle_timeseries(self, params: dict) -> None:
+        """
+        Returns the number of documents per time bucket of a date field as JSON, as -timeseries charts them.
+
+        Args:
+            params (dict): The query string, requiring field and accepting collection and bucket.
+        """
+        name: str = self.collection_name(params)
+        field: str = self.field_param(params)
+        bucket: str = params.get("bucket", "day")
+        if bucket not in GRANULARITIES:
+            raise RequestError(f"bucket must be one of {', '.join(GRANULARITIES)}, got {bucket!r}")
+        points: list = self.cached_query((name, "timeseries", field, bucket),
+                                         lambda: time_series(self.server.db[name], field, bucket))
+        self.send_json(200, [dataclasses.asdict(point) for point in points])
+
+    def handle_stats(self, params: dict) -> None:
+        """
+        Returns summary statistics of a numeric field as JSON, answering 404 if it holds no numbers.
+
+        Args:
+            params (dict): The query string, requiring field and accepting collection.
+        """
+        name: str = self.collection_name(params)
+        field: str = self.field_param(params)
+        stats = self.cached_query((name, "stats", field), lambda: numeric_stats(self.server.db[name], field),
+                                  count=lambda s: s.count)
+        self.send_json(200, dataclasses.asdict(stats))
+
+    def handle_events(self, params: dict) -> None:
+        """
+        Pushes the collection's change events to the browser as Server-Sent Events.
+
+        The response stays open until the browser disconnects. Servers without change
+        streams get a 501, which tells the page to fall back to polling.
+
+        Args:
+            params (dict): The query string, accepting collection.
+        """
+        name: str = self.collection_name(params)
+        try:
+            stream = open_change_stream(self.server.db[name])
+        except ChangeStreamsUnsupportedError as e:
+            self.send_json(501, {"error": str(e)})
+            return
+
+        with stream:
+            self.close_connection = True
+            self.send_response(200)
+            self.send_header("Content-Type", "text/event-stream")
+            self.send_header("Cache-Control", "no-cache")
+            self.send_cors_headers()
+            self.end_headers()
+            try:
+                follow_changes(stream, lambda change: self.send_change(name, change), on_idle=self.send_keepalive)
+            except (BrokenPipeError, ConnectionResetError):
+                # the browser closed the page
+                pass
+            except pymongo.errors.PyMongoError as e:
+                # the status line has gone out already, so there's no error response to send
+                get_logger().warning("change stream on %s failed: %s", name, e)
+
+    def handle_health(self, params: dict) -> None:
+        """
+        Reports whether the last health check reached MongoDB, with a 200 if so and a 503 if not.
+
+        Args:
+            params (dict): The query string, unused.
+        """
+        status: dict = self.server.health.status()
+        self.send_json(200 if status["status"] == "ok" else 503, status)
+
+    def handle_metrics(self, params: dict) -> None:
+        """
+        Exports the server's metrics in the Prometheus text format.
+
+        Args:
+            params (dict): The query string, unused.
+        """
+        if self.server.metrics is None:
+            self.send_json(501, {"error": "metrics need the prometheus_client package"})
+            return
+        content_type, body = self.server.metrics.exposition()
+        self.send_body(200, content_type, body)
+
+    def time_query(self, name: str, fn, count=len):
+        """
+        Runs a query against a collection, recording it in the server's metrics.
+
+        Args:
+            name (str): The collection queried.
+            fn (callable): Runs the query and returns its result.
+            count (callable): Says how many documents a result holds.
+
+        Returns:
+            What fn returns.
+        """
+        if self.server.metrics is None:
+            return fn()
+        return self.server.metrics.time_query(name, fn, count)
+
+    def cached_query(self, key: tuple, fn, count=len):
+        """
+        Answers a query from the server's cache, or runs and times it on a miss.
+
+        Args:
+            key (tuple): The cache key, whose first element is the collection queried.
+            fn (callable): Runs the query and returns its result.
+            count (callable): Says how many documents a result holds.
+
+        Returns:
+            What fn returns, possibly from an earlier identical request.
+        """
+        name: str = key[0]
+        result, hit = self.server.cache.load(key, lambda: self.time_query(name, fn, count))
+        if hit and self.server.metrics is not None:
+            self.server.metrics.cache_hits.labels(name).inc()
+        return result
+
+    def send_change(self, name: str, change: dict) -> None:
+        """
+        Drops the collection's cached results and forwards the change to the browser.
+
+        Args:
+            name (str): The collection that changed.
+            change (dict): The change event.
+        """
+        # invalidate first, so the reload the event triggers doesn't get the stale page back
+        self.server.cache.invalidate(name)
+        self.send_event(json_util.dumps(change))
+
+    def send_event(self, data: str) -> None:
+        """
+        Writes one Server-Sent Event.
+
+        Args:
+            data (str): The event payload, on a single line.
+        """
+        self.wfile.write(f"data: {data}\n\n".encode("utf-8"))
+        self.wfile.flush()
+
+    def send_keepalive(self) -> None:
+        """
+        Writes an SSE comment, which browsers ignore.
+
+        This is how a closed connection gets noticed while the collection is quiet.
+        """
+        self.wfile.write(b": keepalive\n\n")
+        self.wfile.flush()
+
+    def collection_name(self, params: dict) -> str:
+        """
+        Picks the collection named in the request, or the configured one.
+
+        Args:
+            params (dict): The query string.
+        """
+        return params.get("collection") or self.server.cfg.collection
+
+    @staticmethod
+    def field_param(params: dict) -> str:
+        """
+        Reads the field parameter the chart endpoints require.
+
+        Args:
+            params (dict): The query string.
+        """
+        field: str = params.get("field", "")
+        if not field:
+            raise RequestError("field is required")
+        return field
+
+    @staticmethod
+    def query_filter(params: dict) -> dict:
+        """
+        Parses the filter parameter, defaulting to matching everything.
+
+        Args:
+            params (dict): The query string.
+        """
+        if "filter" not in params:
+            return {}
+        try:
+            return parse_filter_json(params["filter"])
+        except ValueError as e:
+            raise RequestError(str(e)) from e
+
+    @staticmethod
+    def int_param(params: dict, name: str, default: int) -> int:
+        """
+        Reads a non-negative integer parameter.
+
+        Args:
+            params (dict): The query string.
+            name (str): The parameter to read.
+            default (int): The value used when the parameter is absent.
+        """
+        if name not in params:
+            return default
+        try:
+            value: int = int(params[name])
+        except ValueError as e:
+            raise RequestError(f"{name} must be an integer, got {params[name]!r}") from e
+        if value < 0:
+            raise RequestError(f"{name} must not be negative")
+        return value
+
+    @classmethod
+    def limit_param(cls, params: dict) -> int:
+        """
+        Reads the limit parameter, which must be between 1 and MAX_PAGE_SIZE.
+
+        Args:
+            params (dict): The query string.
+        """
+        limit: int = cls.int_param(params, "limit", DEFAULT_PAGE_SIZE)
+        if not 0 < limit <= MAX_PAGE_SIZE:
+            raise RequestError(f"limit must be between 1 and {MAX_PAGE_SIZE}, got {limit}")
+        return limit
+
+    def send_json(self, status: int, payload) -> None:
+        """
+        Sends a payload encoded as relaxed Extended JSON.
+
+        Args:
+            status (int): The HTTP status code.
+            payload: Anything json_util can encode, including BSON types.
+        """
+        self.send_body(status, "application/json", json_util.dumps(payload))
+
+    def send_body(self, status: int, content_type: str, body: str) -> None:
+        """
+        Sends a complete response.
+
+        Args:
+            status (int): The HTTP status code.
+            content_type (str): The Content-Type header value.
+            body (str): The response body.
+        """
+        data: bytes = body.encode("utf-8")
+        self.send_response(status)
+        self.send_header("Content-Type", content_type)
+        self.send_header("Content-Length", str(len(data)))
+        self.send_cors_headers()
+        self.end_headers()
+        self.wfile.write(data)
+
+    def send_cors_headers(self) -> None:
+        """
+        Lets pages on the origins -cors-origin allows read the response; without the flag, only same-origin pages can.
+        """
+        allowed: list = self.server.cfg.cors_origins
+        if not allowed:
+            return
+        if "*" in allowed:
+            self.send_header("Access-Control-Allow-Origin", "*")
+            return
+        # the header takes a single origin, so the request's is echoed back if it's one of those allowed
+        origin: str = self.headers.get("Origin", "")
+        if origin in allowed:
+            self.send_header("Access-Control-Allow-Origin", origin)
+        self.send_header("Vary", "Origin")
+
+
+def parse_addr(addr: str) -> tuple:
+    """
+    Splits a listen address such as localhost:8080 or :8080 into host and port.
+
+    Args:
+        addr (str): The address to parse; an empty host listens on every interface.
+    """
+    host, sep, port = addr.rpartition(":")
+    if not sep or not port.isdigit():
+        raise ValueError(f"invalid listen address {addr!r}, expected host:port")
+    return host, int(port)
+
+
+def start_server(cfg: Config, addr: str) -> None:
+    """
+    Connects to MongoDB and serves the dashboard until interrupted.
+
+    On an interrupt the server stops accepting connections and gives running requests
+    cfg.shutdown_grace seconds to finish before the client is closed, and the
+    KeyboardInterrupt is re-raised.
+
+    Args:
+        cfg (Config): The configuration naming the server, database and default collection.
+        addr (str): The address to listen on, e.g. localhost:8080.
+
+    Raises:
+        ShutdownTimeoutError: If requests were cut off because they outran the grace period.
+    """
+    client: pymongo.MongoClient = with_retry(cfg.retries + 1, lambda: connect(cfg.uri, cfg.timeout, **cfg.client_options()))
+    try:
+        httpd: DashboardServer = DashboardServer(parse_addr(addr), cfg, client)
+    except BaseException:
+        client.close()
+        raise
+
+    print(f"Serving {cfg.database} on http://{addr}")
+    with httpd:
+        httpd.health.start()
+        try:
+            httpd.serve_forever()
+        except KeyboardInterrupt:
+            get_logger().info("shutting down, waiting up to %gs for %d requests", cfg.shutdown_grace, httpd.in_flight)
+            httpd.shutdown_gracefully(cfg.shutdown_grace)
+            raise
+        finally:
+            httpd.health.stop()
+            # the health monitor may have replaced the client it was given
+            httpd.client.close()
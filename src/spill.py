@@ -0,0 +1,117 @@
+import tempfile
+from dataclasses import dataclass
+from typing import Optional
+
+from bson import decode_file_iter, encode
+
+from logs import get_logger
+
+
+# how much of a result set SpillBuffer keeps in memory by default before spilling the rest
+DEFAULT_MAX_MEMORY_BYTES: int = 256 * 1024 * 1024
+
+
+@dataclass
+class SpillConfig:
+    """
+    Caps the memory a buffered result set takes, spilling the rest to a temporary file.
+
+    Attributes:
+        max_memory_bytes (int): The most documents held in memory may take, measured as encoded BSON.
+        temp_dir (None | str): The directory the spill file goes in; None uses the system default.
+    """
+    max_memory_bytes: int = DEFAULT_MAX_MEMORY_BYTES
+    temp_dir: Optional[str] = None
+
+
+class SpillBuffer:
+    """
+    Documents kept for reading more than once, in memory up to a budget and in a temporary BSON file beyond it.
+
+    Documents are measured by their encoded BSON size, which understates what the
+    decoded dicts take but grows with it. The spill file is deleted when the buffer
+    is closed, and has no name on disk on systems that allow that, so nothing is
+    left behind if the process dies; use the buffer as a context manager.
+
+    Attributes:
+        config (SpillConfig): The memory budget and where to spill.
+        memory_bytes (int): The encoded size of the documents held in memory.
+        spilled (int): How many documents are in the spill file.
+    """
+
+    def __init__(self, config: SpillConfig) -> None:
+        """
+        Constructs a new, empty SpillBuffer.
+
+        Args:
+            config (SpillConfig): The memory budget and where to spill.
+        """
+        self.config: SpillConfig = config
+        self.memory_bytes: int = 0
+        self.spilled: int = 0
+        self._docs: list = []
+        self._file = None
+
+    def __enter__(self) -> "SpillBuffer":
+        return self
+
+    def __exit__(self, *exc) -> None:
+        self.close()
+
+    def __len__(self) -> int:
+        return len(self._docs) + self.spilled
+
+    def append(self, doc: dict) -> None:
+        """
+        Adds a document, spilling it to disk if it would take the buffer over budget.
+
+        Once one document has spilled, every later one does too, so reading back keeps the order they were added in.
+
+        Args:
+            doc (dict): The document.
+        """
+        data: bytes = encode(doc)
+        if self._file is None and self.memory_bytes + len(data) <= self.config.max_memory_bytes:
+            self._docs.append(doc)
+            self.memory_bytes += len(data)
+            return
+
+        if self._file is None:
+            self._file = tempfile.TemporaryFile(prefix="mongoviz-spill-", suffix=".bson", dir=self.config.temp_dir)
+            get_logger().info("buffered results passed %d bytes, spilling the rest to disk", self.config.max_memory_bytes)
+        self._file.seek(0, 2)
+        self._file.write(data)
+        self.spilled += 1
+
+    def extend(self, docs) -> None:
+        """
+        Adds each of docs in turn.
+
+        Args:
+            docs (iterable): The documents.
+        """
+        for doc in docs:
+            self.append(doc)
+
+    def __iter__(self):
+        """
+        Reads the documents back in the order they were added; each iteration starts from the beginning.
+
+        Don't append while iterating, nor interleave two iterations, as they share the spill file's position.
+        """
+        yield from self._docs
+        if self._file is not None:
+            self._file.flush()
+            self._file.seek(0)
+            yield from decode_file_iter(self._file)
+
+    def close(self) -> None:
+        """
+        Drops the documents and deletes the spill file.
+        """
+        self._docs = []
+        self.memory_bytes = 0
+        self.spilled = 0
+        if self._file is not None:
+            self._file.close()
+            self._file = None
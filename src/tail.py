@@ -0,0 +1,147 @@
+import datetime
+import time
+
+from bson import ObjectId
+from pymongo.collection import Collection
+
+from analysis import objectid_timestamp
+from changes import ChangeStreamsUnsupportedError, follow_changes, open_change_stream
+from logs import get_logger
+
+
+# how many of the newest documents tail prints before waiting for more
+DEFAULT_TAIL: int = 10
+
+# how often tail asks a server without change streams for new documents
+DEFAULT_POLL_INTERVAL: float = 2.0
+
+# ObjectIds only order by time to the second, and clients' clocks disagree by a little
+# more, so each poll looks back this far and skips what it has already printed
+POLL_OVERLAP: datetime.timedelta = datetime.timedelta(seconds=5)
+
+
+def recent_documents(collection: Collection, n: int) -> list:
+    """
+    Fetches the newest documents by the time encoded in their ObjectId _id.
+
+    Args:
+        collection (Collection): The collection to read.
+        n (int): How many documents to return; 0 returns none.
+
+    Returns:
+        list: Up to n documents, oldest first.
+    """
+    if n <= 0:
+        return []
+    query: dict = {"_id": {"$type": "objectId"}}
+    with collection.find(query, sort=[("_id", -1)], limit=n) as cursor:
+        docs: list = list(cursor)
+    docs.reverse()
+    return docs
+
+
+class Poller:
+    """
+    Finds documents inserted since the last poll, for servers without change streams.
+
+    Only documents with an ObjectId _id are seen, and a document is new if its id's
+    timestamp is recent; one inserted with an id generated long before shows up
+    only if it still falls within POLL_OVERLAP of the newest seen.
+
+    Attributes:
+        since (datetime.datetime): The newest id timestamp seen so far, or when polling began if none.
+    """
+
+    def __init__(self, collection: Collection, seen: list = ()) -> None:
+        """
+        Constructs a new Poller that reports documents inserted from now on, reading those already there.
+
+        Args:
+            collection (Collection): The collection to poll.
+            seen (list): Documents already printed, e.g. from recent_documents.
+        """
+        self.collection: Collection = collection
+        self._seen: dict = {}
+        if seen:
+            self.since: datetime.datetime = max(objectid_timestamp(doc["_id"]) for doc in seen)
+        else:
+            # an empty collection, or one tailed with n=0, starts from now rather than replaying everything
+            self.since = datetime.datetime.now(datetime.timezone.utc)
+        self._remember(seen)
+        # older documents within the overlap weren't among those printed, but they aren't new either
+        self.poll()
+
+    def _remember(self, docs: list) -> None:
+        """
+        Records documents as printed and moves since up to the newest of them.
+
+        Args:
+            docs (list): Documents with ObjectId ids.
+        """
+        for doc in docs:
+            created: datetime.datetime = objectid_timestamp(doc["_id"])
+            self._seen[doc["_id"]] = created
+            self.since = max(self.since, created)
+        # ids older than the overlap are never queried again, so they needn't be remembered
+        cutoff: datetime.datetime = self.since - POLL_OVERLAP
+        self._seen = {oid: created for oid, created in self._seen.items() if created >= cutoff}
+
+    def poll(self) -> list:
+        """
+        Fetches the documents inserted since the last poll.
+
+        Returns:
+            list: The new documents in _id order.
+        """
+        query: dict = {"_id": {"$gte": ObjectId.from_datetime(self.since - POLL_OVERLAP)}}
+        with self.collection.find(query, sort=[("_id", 1)]) as cursor:
+            docs: list = [doc for doc in cursor if doc["_id"] not in self._seen]
+        self._remember(docs)
+        return docs
+
+
+def tail(collection: Collection, fn, n: int = DEFAULT_TAIL, interval: float = DEFAULT_POLL_INTERVAL,
+         sleep=time.sleep) -> None:
+    """
+    Passes the newest n documents to fn, then each newly inserted one as it appears, until interrupted.
+
+    A change stream delivers inserts as they happen where the server has one. A
+    standalone server doesn't, so it is polled every interval seconds instead.
+
+    Args:
+        collection (Collection): The collection to follow.
+        fn (callable): Called with each document.
+        n (int): How many existing documents to start with.
+        interval (float): Seconds between polls when polling.
+        sleep (callable): Waits a number of seconds, replaceable for tests.
+    """
+    # open the stream first, so nothing inserted while the recent documents are read is missed
+    try:
+        stream = open_change_stream(collection)
+    except ChangeStreamsUnsupportedError:
+        stream = None
+
+    if stream is not None:
+        with stream:
+            recent: list = recent_documents(collection, n)
+            for doc in recent:
+                fn(doc)
+            # an insert that raced the read above is in both, so it's only passed on once
+            printed: set = {doc["_id"] for doc in recent}
+
+            def on_change(change: dict) -> None:
+                if change["operationType"] == "insert" and change["documentKey"]["_id"] not in printed:
+                    fn(change["fullDocument"])
+
+            follow_changes(stream, on_change)
+        return
+
+    recent = recent_documents(collection, n)
+    for doc in recent:
+        fn(doc)
+    get_logger().info("change streams aren't available, polling %s every %gs", collection.name, interval)
+    poller: Poller = Poller(collection, recent)
+    while True:
+        sleep(interval)
+        for doc in poller.poll():
+            fn(doc)
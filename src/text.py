@@ -0,0 +1,36 @@
+import re
+
+
+# a run of letters or digits, keeping apostrophes inside words so "don't" stays one token
+TOKEN = re.compile(r"[^\W_]+(?:['’][^\W_]+)*")
+
+# common English words that would otherwise top every word count
+STOPWORDS: frozenset = frozenset("""
+a about above after again against all am an and any are as at be because been before being below between both
+but by can could did do does doing down during each few for from further had has have having he her here hers
+herself him himself his how i if in into is it its itself just me more most my myself no nor not now of off on
+once only or other our ours ourselves out over own same she should so some such than that the their theirs them
+themselves then there these they this those through to too under until up very was we were what when where which
+while who whom why will with would you your yours yourself yourselves
+""".split())
+
+
+def tokenize(text: str) -> list:
+    """
+    Splits text into lowercase words, dropping stopwords and single characters.
+
+    Words are split on whitespace and punctuation, except apostrophes inside a word,
+    and a curly apostrophe is treated like a straight one.
+
+    Args:
+        text (str): The text to split.
+
+    Returns:
+        list: The remaining words, in the order they appear.
+    """
+    words: list = []
+    for match in TOKEN.finditer(text.lower()):
+        word: str = match.group().replace("’", "'")
+        if len(word) > 1 and word not in STOPWORDS:
+            words.append(word)
+    return words
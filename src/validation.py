@@ -0,0 +1,164 @@
+import json
+from dataclasses import dataclass
+
+from bson import json_util
+
+
+# how many failures a SchemaValidationError spells out before summarizing the rest
+MAX_REPORTED: int = 5
+
+
+@dataclass
+class ValidationError:
+    """
+    One way a document failed to match a JSON Schema.
+
+    Attributes:
+        index (int): The position of the document in the import, starting at 0.
+        path (str): The dotted path of the failing field, empty for the document itself.
+        message (str): What the schema expected.
+    """
+    index: int
+    path: str
+    message: str
+
+    def __str__(self) -> str:
+        return f"document {self.index}: {self.path or '(document)'}: {self.message}"
+
+
+class SchemaValidationError(ValueError):
+    """
+    Raised by a strict import when documents don't match the schema, before any are written.
+
+    Attributes:
+        failures (list): Every ValidationError found.
+        source (str): The file or stream the documents came from, empty if not known.
+    """
+
+    def __init__(self, failures: list, source: str = "") -> None:
+        """
+        Constructs a new SchemaValidationError.
+
+        Args:
+            failures (list): The ValidationErrors found.
+            source (str): The file or stream the documents came from, named at the start of the message.
+        """
+        lines: list = [str(failure) for failure in failures[:MAX_REPORTED]]
+        if len(failures) > MAX_REPORTED:
+            lines.append(f"... and {len(failures) - MAX_REPORTED} more")
+        prefix: str = f"{source}: " if source else ""
+        super().__init__(f"{prefix}{len(failures)} schema violations, nothing was imported:\n  " + "\n  ".join(lines))
+        self.failures: list = failures
+        self.source: str = source
+
+
+def compile_schema(schema: dict):
+    """
+    Checks a JSON Schema and builds a validator for it, using the draft its $schema names.
+
+    Args:
+        schema (dict): The JSON Schema.
+
+    Returns:
+        A jsonschema validator instance.
+
+    Raises:
+        ValueError: If jsonschema isn't installed or the schema itself is invalid.
+    """
+    try:
+        import jsonschema
+    except ImportError as e:
+        raise ValueError("validating against a JSON Schema needs the jsonschema package (pip install jsonschema)") from e
+
+    cls = jsonschema.validators.validator_for(schema)
+    try:
+        cls.check_schema(schema)
+    except jsonschema.SchemaError as e:
+        raise ValueError(f"invalid JSON Schema: {e.message}") from e
+    return cls(schema)
+
+
+def load_json_schema(path: str) -> dict:
+    """
+    Reads a JSON Schema from a file and checks that it is a valid schema.
+
+    Args:
+        path (str): Path to the schema file.
+
+    Returns:
+        dict: The schema.
+
+    Raises:
+        ValueError: If the file can't be read, isn't JSON, or isn't a valid schema.
+    """
+    try:
+        with open(path, encoding="utf-8") as f:
+            schema = json.load(f)
+    except OSError as e:
+        raise ValueError(f"could not read schema file {path}: {e}") from e
+    except json.JSONDecodeError as e:
+        raise ValueError(f"schema file {path} is not valid JSON: {e}") from e
+
+    if not isinstance(schema, (dict, bool)):
+        raise ValueError(f"schema file {path} must contain an object, got {type(schema).__name__}")
+    try:
+        compile_schema(schema)
+    except ValueError as e:
+        raise ValueError(f"{path}: {e}") from e
+    return schema
+
+
+def document_errors(validator, index: int, doc: dict) -> list:
+    """
+    Lists every way one document fails to match a schema.
+
+    The document is checked in its relaxed Extended JSON form, the way it looks in an
+    import file, so an ObjectId is {"$oid": ...} and a date is {"$date": ...}.
+
+    Args:
+        validator: A validator from compile_schema.
+        index (int): The document's position in the import.
+        doc (dict): The document to check.
+    """
+    instance = json.loads(json_util.dumps(doc))
+    return [ValidationError(index=index, path=".".join(str(key) for key in error.absolute_path), message=error.message)
+            for error in validator.iter_errors(instance)]
+
+
+def validate_documents(docs, schema: dict) -> list:
+    """
+    Checks every document against a JSON Schema.
+
+    Args:
+        docs (iterable): The documents to check.
+        schema (dict): The JSON Schema they should match.
+
+    Returns:
+        list: A ValidationError for each failure, in document order; empty if all match.
+    """
+    validator = compile_schema(schema)
+    failures: list = []
+    for index, doc in enumerate(docs):
+        failures.extend(document_errors(validator, index, doc))
+    return failures
+
+
+def skip_invalid(docs, schema: dict, failures: list):
+    """
+    Passes on the documents that match a JSON Schema, lazily, and records the rest.
+
+    Args:
+        docs (iterable): The documents to check.
+        schema (dict): The JSON Schema they should match.
+        failures (list): Receives a ValidationError for each failure as documents are read.
+
+    Yields:
+        dict: Each document that matches.
+    """
+    validator = compile_schema(schema)
+    for index, doc in enumerate(docs):
+        errors: list = document_errors(validator, index, doc)
+        if errors:
+            failures.extend(errors)
+            continue
+        yield doc
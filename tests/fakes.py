@@ -0,0 +1,74 @@
+import threading
+from types import SimpleNamespace
+
+import pymongo
+
+
+class FakeCursor:
+    """
+    A list of documents that can be used like a pymongo cursor, including as a context manager.
+    """
+
+    def __init__(self, docs: list) -> None:
+        self.docs: list = docs
+
+    def __enter__(self) -> "FakeCursor":
+        return self
+
+    def __exit__(self, *exc) -> None:
+        pass
+
+    def __iter__(self):
+        return iter(self.docs)
+
+    def close(self) -> None:
+        pass
+
+
+class FakeCollection:
+    """
+    Just enough of a pymongo Collection for the importers and queries, keeping its documents in a list.
+
+    Attributes:
+        name (str): The collection's name.
+        docs (list): The documents inserted so far.
+        batches (list): The size of each insert_many call, in order.
+        fail_on_batch (None | int): The insert_many call, counting from 1, that raises AutoReconnect
+            as if the connection dropped, inserting nothing.
+    """
+
+    def __init__(self, name: str = "test", docs: list = None, fail_on_batch: int = None) -> None:
+        self.name: str = name
+        self.docs: list = list(docs or [])
+        self.batches: list = []
+        self.fail_on_batch = fail_on_batch
+        self._lock: threading.Lock = threading.Lock()
+
+    def insert_many(self, docs: list, ordered: bool = True):
+        with self._lock:
+            self.batches.append(len(docs))
+            if len(self.batches) == self.fail_on_batch:
+                raise pymongo.errors.AutoReconnect("connection dropped")
+            self.docs.extend(docs)
+        return SimpleNamespace(inserted_ids=[doc.get("_id") for doc in docs])
+
+    def find(self, query_filter: dict = None, projection=None, skip: int = 0, limit: int = 0, sort=None, **kwargs):
+        # equality filters are all the tests need
+        matches: list = [doc for doc in self.docs
+                         if all(doc.get(key) == value for key, value in (query_filter or {}).items())]
+        matches = matches[skip:]
+        return FakeCursor(matches[:limit] if limit else matches)
+
+
+class FakeDatabase(dict):
+    """
+    Maps collection names to FakeCollections, creating them on first use as a Database does.
+    """
+    name: str = "test"
+
+    def __missing__(self, name: str) -> FakeCollection:
+        self[name] = FakeCollection(name)
+        return self[name]
+
+    def list_collection_names(self) -> list:
+        return list(self)
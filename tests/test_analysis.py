@@ -0,0 +1,65 @@
+import unittest
+from decimal import Decimal
+from unittest import mock
+
+from bson import Decimal128
+
+from analysis import estimate_cardinality, numeric_histogram, numeric_stats
+
+
+def aggregating(*results: list) -> mock.Mock:
+    """
+    A collection whose successive aggregate calls return results, in turn.
+    """
+    collection = mock.Mock()
+    collection.name = "menu"
+    collection.aggregate.side_effect = [iter(result) for result in results]
+    collection.count_documents.return_value = 0
+    return collection
+
+
+class CardinalityTest(unittest.TestCase):
+
+    def test_sampling_every_document_of_a_sparse_field_is_exact(self):
+        # 100 documents, only 30 of which hold the field, in three values
+        collection = aggregating([{"_id": "a", "count": 10}, {"_id": "b", "count": 10}, {"_id": "c", "count": 10}])
+        collection.estimated_document_count.return_value = 100
+        estimate = estimate_cardinality(collection, "cuisine", 1000)
+        self.assertTrue(estimate.exact)
+        self.assertEqual((estimate.estimate, estimate.sample_size, estimate.with_field), (3, 100, 30))
+
+    def test_a_partial_sample_is_approximate(self):
+        collection = aggregating([{"_id": "a", "count": 1}, {"_id": "b", "count": 1}])
+        collection.estimated_document_count.return_value = 100
+        estimate = estimate_cardinality(collection, "cuisine", 10)
+        self.assertFalse(estimate.exact)
+        self.assertEqual((estimate.sample_size, estimate.with_field), (10, 2))
+
+
+class NumericStatsTest(unittest.TestCase):
+
+    def test_decimal_values_come_back_as_floats(self):
+        # $min, $max and $avg over a decimal field give Decimal128, which float() refuses
+        collection = aggregating([{"min": Decimal128(Decimal("1.50")), "max": Decimal128(Decimal("12.25")),
+                                   "avg": Decimal128(Decimal("6.875")), "std_dev": 5.375, "count": 2}])
+        stats = numeric_stats(collection, "price")
+        self.assertEqual((stats.min, stats.max, stats.avg, stats.std_dev), (1.5, 12.25, 6.875, 5.375))
+        self.assertEqual(stats.count, 2)
+
+
+class NumericHistogramTest(unittest.TestCase):
+
+    def test_decimal_values_fall_in_the_float_ranges(self):
+        stats: list = [{"min": Decimal128(Decimal("0")), "max": Decimal128(Decimal("10")),
+                        "avg": Decimal128(Decimal("4")), "std_dev": 4.0, "count": 3}]
+        # what $bucket answers when it groups doubles: the lower edge of each range as _id
+        counts: list = [{"_id": 0.0, "count": 2}, {"_id": "max", "count": 1}]
+        collection = aggregating(stats, counts)
+        buckets: list = numeric_histogram(collection, "price", bins=2)
+        self.assertEqual([(b.lo, b.hi, b.count) for b in buckets], [(0.0, 5.0, 2), (5.0, 10.0, 1)])
+        pipeline: list = collection.aggregate.call_args.args[0]
+        self.assertEqual(pipeline[-1]["$bucket"]["groupBy"], {"$toDouble": "$price"})
+
+
+if __name__ == "__main__":
+    unittest.main()
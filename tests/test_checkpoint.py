@@ -0,0 +1,105 @@
+import io
+import json
+import os
+import tempfile
+import unittest
+
+from checkpoint import Checkpoint
+from importers import BatchInsertError, ImportOptions, import_json_reader
+from tests.fakes import FakeCollection
+
+
+class CheckpointTest(unittest.TestCase):
+
+    def setUp(self):
+        directory = tempfile.TemporaryDirectory()
+        self.addCleanup(directory.cleanup)
+        self.directory: str = directory.name
+        self.path: str = os.path.join(directory.name, "import.checkpoint")
+
+    def write(self, data) -> None:
+        with open(self.path, "w", encoding="utf-8") as f:
+            json.dump(data, f)
+
+    def test_progress_survives_a_reload(self):
+        checkpoint = Checkpoint(self.path, "restaurants")
+        checkpoint.advance("a.json", 500)
+        checkpoint.finish("b.json")
+
+        resumed = Checkpoint(self.path, "restaurants", resume=True)
+        self.assertEqual(resumed.offset("a.json"), 500)
+        self.assertFalse(resumed.done("a.json"))
+        self.assertTrue(resumed.done("b.json"))
+        self.assertEqual(resumed.offset("c.json"), 0)
+
+    def test_save_leaves_no_temporary_files(self):
+        Checkpoint(self.path, "restaurants").advance("a.json", 5)
+        self.assertEqual(os.listdir(self.directory), ["import.checkpoint"])
+
+    def test_existing_checkpoint_needs_resume(self):
+        Checkpoint(self.path, "restaurants").advance("a.json", 5)
+        with self.assertRaisesRegex(ValueError, "-resume"):
+            Checkpoint(self.path, "restaurants")
+
+    def test_refuses_a_checkpoint_for_another_collection(self):
+        Checkpoint(self.path, "restaurants").advance("a.json", 5)
+        with self.assertRaisesRegex(ValueError, "'restaurants', not 'inspections'"):
+            Checkpoint(self.path, "inspections", resume=True)
+
+    def test_refuses_a_corrupt_checkpoint(self):
+        for data in (["restaurants"], "restaurants", {"collection": "restaurants", "sources": []},
+                     {"collection": "restaurants", "sources": {"a.json": 5}},
+                     {"collection": "restaurants", "sources": {"a.json": {"offset": "5", "done": False}}}):
+            with self.subTest(data=data):
+                self.write(data)
+                with self.assertRaisesRegex(ValueError, "corrupt"):
+                    Checkpoint(self.path, "restaurants", resume=True)
+
+    def test_refuses_a_file_that_is_not_json(self):
+        with open(self.path, "w", encoding="utf-8") as f:
+            f.write('{"collection": "restau')
+        with self.assertRaisesRegex(ValueError, "could not read"):
+            Checkpoint(self.path, "restaurants", resume=True)
+
+    def test_remove_deletes_the_file(self):
+        checkpoint = Checkpoint(self.path, "restaurants")
+        checkpoint.advance("a.json", 5)
+        checkpoint.remove()
+        self.assertFalse(os.path.exists(self.path))
+        # removing twice is harmless, as a finished import may have nothing left to remove
+        checkpoint.remove()
+
+
+class ResumeTest(unittest.TestCase):
+
+    def setUp(self):
+        directory = tempfile.TemporaryDirectory()
+        self.addCleanup(directory.cleanup)
+        self.path: str = os.path.join(directory.name, "import.checkpoint")
+        self.input: str = "".join(json.dumps({"_id": i}) + "\n" for i in range(25))
+
+    def run_import(self, collection: FakeCollection, resume: bool = False):
+        opts = ImportOptions(batch_size=5, checkpoint=Checkpoint(self.path, collection.name, resume))
+        return import_json_reader(collection, io.StringIO(self.input), opts, "in.json")
+
+    def test_resumes_after_the_last_batch_written(self):
+        collection = FakeCollection(fail_on_batch=3)
+        with self.assertRaises(BatchInsertError):
+            self.run_import(collection)
+        self.assertEqual(Checkpoint(self.path, collection.name, resume=True).offset("in.json"), 10)
+
+        collection.fail_on_batch = None
+        collection.batches = []
+        report = self.run_import(collection, resume=True)
+        self.assertEqual(collection.batches, [5, 5, 5])
+        self.assertEqual(report.inserted, 15)
+        self.assertEqual([doc["_id"] for doc in collection.docs], list(range(25)))
+
+        # the checkpoint now says the source is done, so running again writes nothing
+        collection.batches = []
+        self.assertEqual(self.run_import(collection, resume=True).inserted, 0)
+        self.assertEqual(collection.batches, [])
+
+
+if __name__ == "__main__":
+    unittest.main()
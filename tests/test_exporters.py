@@ -0,0 +1,47 @@
+import io
+import unittest
+
+from exporters import export_csv, flatten_document
+
+
+# three levels of subdocument, with an array on the way
+NESTED: dict = {
+    "name": "Morris Park Bake Shop",
+    "address": {"street": "Morris Park Ave", "geo": {"coord": {"lat": 40.84, "lon": -73.85}}},
+    "grades": [{"grade": "A", "score": 2}, {"grade": "B", "score": 6}],
+}
+
+
+class FlattenDocumentTest(unittest.TestCase):
+
+    def test_three_levels_flatten_to_dotted_and_indexed_keys(self):
+        self.assertEqual(flatten_document(NESTED, max_depth=3), {
+            "name": "Morris Park Bake Shop",
+            "address.street": "Morris Park Ave",
+            "address.geo.coord.lat": 40.84,
+            "address.geo.coord.lon": -73.85,
+            "grades.0.grade": "A",
+            "grades.0.score": 2,
+            "grades.1.grade": "B",
+            "grades.1.score": 6,
+        })
+
+    def test_max_depth_keeps_deeper_levels_nested(self):
+        flat: dict = flatten_document(NESTED, max_depth=2)
+        self.assertEqual(flat["address.geo.coord"], {"lat": 40.84, "lon": -73.85})
+        self.assertEqual(flatten_document(NESTED, max_depth=0), NESTED)
+
+    def test_empty_subdocuments_and_arrays_keep_their_field(self):
+        self.assertEqual(flatten_document({"a": {}, "b": []}), {"a": {}, "b": []})
+
+    def test_arrays_can_stay_whole(self):
+        self.assertEqual(flatten_document({"tags": ["x", "y"]}, arrays=False), {"tags": ["x", "y"]})
+
+    def test_csv_gets_a_column_per_flattened_key(self):
+        out = io.StringIO()
+        export_csv(out, [{"a": {"b": {"c": 1}}, "d": 2}])
+        self.assertEqual(out.getvalue().splitlines()[0], "a.b.c,d")
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,108 @@
+import csv
+import json
+import os
+import tempfile
+import unittest
+from types import SimpleNamespace
+
+import pymongo
+
+from importers import BatchInsertError, ImportOptions, batched, import_csv_file, import_directory, import_documents
+from tests.fakes import FakeCollection
+
+
+class RerunCollection(FakeCollection):
+    """
+    A collection already holding every document an upsert sends, so each replaces one; fail_on_batch applies too.
+    """
+
+    def bulk_write(self, requests: list, ordered: bool = False):
+        self.batches.append(len(requests))
+        if len(self.batches) == self.fail_on_batch:
+            raise pymongo.errors.AutoReconnect("connection dropped")
+        return SimpleNamespace(matched_count=len(requests), upserted_count=0)
+
+
+class BatchedTest(unittest.TestCase):
+
+    def test_splits_into_full_batches_and_a_remainder(self):
+        self.assertEqual([len(batch) for batch in batched(range(2500), 1000)], [1000, 1000, 500])
+
+    def test_empty_input_yields_no_batches(self):
+        self.assertEqual(list(batched([], 1000)), [])
+
+    def test_reads_lazily(self):
+        def docs():
+            yield from range(3)
+            raise AssertionError("read past the first batch")
+
+        self.assertEqual(next(batched(docs(), 3)), [0, 1, 2])
+
+    def test_refuses_a_size_below_one(self):
+        with self.assertRaises(ValueError):
+            list(batched(range(3), 0))
+
+
+class ImportDocumentsTest(unittest.TestCase):
+
+    def test_2500_documents_go_in_three_batches(self):
+        collection = FakeCollection()
+        report = import_documents(collection, ({"_id": i} for i in range(2500)), batch_size=1000)
+        self.assertEqual(collection.batches, [1000, 1000, 500])
+        self.assertEqual(report.inserted, 2500)
+
+    def test_failed_batch_reports_what_was_inserted_before_it(self):
+        collection = FakeCollection(fail_on_batch=2)
+        with self.assertRaises(BatchInsertError) as caught:
+            import_documents(collection, ({"_id": i} for i in range(2500)), batch_size=1000)
+        self.assertEqual(caught.exception.inserted, 1000)
+        self.assertEqual(len(collection.docs), 1000)
+
+
+class ImportCsvTest(unittest.TestCase):
+
+    def write_csv(self, rows) -> str:
+        directory = tempfile.TemporaryDirectory()
+        self.addCleanup(directory.cleanup)
+        path = os.path.join(directory.name, "rows.csv")
+        with open(path, "w", newline="", encoding="utf-8") as f:
+            writer = csv.writer(f)
+            writer.writerow(["n", "name"])
+            writer.writerows(rows)
+        return path
+
+    def test_rows_are_written_batch_by_batch(self):
+        path = self.write_csv([i, f"row {i}"] for i in range(2500))
+        collection = FakeCollection()
+        report, warnings = import_csv_file(collection, path, {"n": "int"}, ImportOptions(batch_size=1000))
+        self.assertEqual(collection.batches, [1000, 1000, 500])
+        self.assertEqual(report.inserted, 2500)
+        self.assertEqual(warnings, [])
+        self.assertEqual(collection.docs[1], {"n": 1, "name": "row 1"})
+
+    def test_rows_are_written_before_the_rest_of_the_file_is_read(self):
+        path = self.write_csv([i if i != 1500 else "x", f"row {i}"] for i in range(2500))
+        collection = FakeCollection()
+        with self.assertRaisesRegex(ValueError, "rows.csv:1502: column 'n'"):
+            import_csv_file(collection, path, {"n": "int"}, ImportOptions(batch_size=1000))
+        self.assertEqual(collection.batches, [1000])
+
+
+class ImportDirectoryTest(unittest.TestCase):
+
+    def test_failed_upsert_counts_the_documents_it_replaced(self):
+        directory = tempfile.TemporaryDirectory()
+        self.addCleanup(directory.cleanup)
+        with open(os.path.join(directory.name, "part-1.json"), "w", encoding="utf-8") as f:
+            f.writelines(json.dumps({"k": i}) + "\n" for i in range(10))
+
+        collection = RerunCollection(fail_on_batch=2)
+        report = import_directory(collection, directory.name, opts=ImportOptions(batch_size=5, upsert_key="k"))
+        result = report.files["part-1.json"]
+        self.assertIsNotNone(result.error)
+        self.assertEqual((result.report.inserted, result.report.replaced), (0, 5))
+        self.assertEqual(report.summary(), "inserted 0, replaced 5 from 1 files")
+
+
+if __name__ == "__main__":
+    unittest.main()
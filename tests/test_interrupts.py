@@ -0,0 +1,59 @@
+import os
+import runpy
+import signal
+import unittest
+from unittest import mock
+
+import config
+import mongo_connection
+import plot_script
+import schema
+from importers import import_documents
+from tests.fakes import FakeCollection
+
+
+def interrupted_after(n: int):
+    """
+    Yields n documents, then raises KeyboardInterrupt as Ctrl-C would partway through reading an import.
+    """
+    for i in range(n):
+        yield {"_id": i}
+    raise KeyboardInterrupt
+
+
+class InterruptTest(unittest.TestCase):
+
+    @unittest.skipUnless(hasattr(signal, "SIGTERM") and os.name == "posix", "needs POSIX signals")
+    def test_sigterm_raises_keyboard_interrupt(self):
+        previous = signal.signal(signal.SIGTERM, plot_script.raise_interrupt)
+        try:
+            with self.assertRaises(KeyboardInterrupt):
+                # Python runs the handler in the main thread as soon as kill returns
+                os.kill(os.getpid(), signal.SIGTERM)
+        finally:
+            signal.signal(signal.SIGTERM, previous)
+
+    def test_interrupt_cancels_import_after_the_batches_sent(self):
+        collection = FakeCollection()
+        with self.assertRaises(KeyboardInterrupt):
+            import_documents(collection, interrupted_after(1500), batch_size=1000)
+        self.assertEqual(collection.batches, [1000])
+        self.assertEqual(len(collection.docs), 1000)
+
+    def test_interrupted_command_disconnects_and_exits_130(self):
+        driver = mock.MagicMock()
+        # the script installs its own SIGTERM handler, which shouldn't outlive the test
+        self.addCleanup(signal.signal, signal.SIGTERM, signal.getsignal(signal.SIGTERM))
+        with mock.patch.object(config, "parse_args", return_value=config.Config(schema=True)), \
+                mock.patch.object(mongo_connection.MongoDriver, "from_uri", return_value=driver), \
+                mock.patch.object(schema, "infer_schema", side_effect=KeyboardInterrupt), \
+                mock.patch("sys.stderr"):
+            with self.assertRaises(SystemExit) as caught:
+                runpy.run_path(plot_script.__file__, run_name="__main__")
+        self.assertEqual(caught.exception.code, plot_script.EXIT_INTERRUPTED)
+        driver.connect.assert_called_once()
+        driver.disconnect.assert_called_once()
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,83 @@
+import threading
+import unittest
+
+import pymongo
+
+from query import NamedQuery, QueryManyError, QueryOptions, query_many
+from tests.fakes import FakeCollection, FakeDatabase
+
+
+class MeetingCollection(FakeCollection):
+    """
+    A collection whose finds wait for the other queries' to start, so they only pass if run at the same time.
+    """
+
+    def __init__(self, name: str, docs: list, barrier: threading.Barrier) -> None:
+        super().__init__(name, docs)
+        self.barrier: threading.Barrier = barrier
+
+    def find(self, *args, **kwargs):
+        self.barrier.wait(timeout=10)
+        return super().find(*args, **kwargs)
+
+
+class FailingCollection(FakeCollection):
+    """
+    A collection every find on fails with a server error.
+    """
+
+    def find(self, *args, **kwargs):
+        raise pymongo.errors.OperationFailure("too many open cursors", code=2200)
+
+
+def three_collections(barrier: threading.Barrier = None) -> FakeDatabase:
+    db: FakeDatabase = FakeDatabase()
+    contents: dict = {
+        "restaurants": [{"_id": 1, "borough": "Bronx"}, {"_id": 2, "borough": "Queens"}],
+        "inspections": [{"_id": 10, "grade": "A"}],
+        "neighborhoods": [{"_id": 20, "name": "Astoria"}, {"_id": 21, "name": "Fordham"}, {"_id": 22, "name": "Soho"}],
+    }
+    for name, docs in contents.items():
+        db[name] = MeetingCollection(name, docs, barrier) if barrier else FakeCollection(name, docs)
+    return db
+
+
+class QueryManyTest(unittest.TestCase):
+
+    def test_three_collections_are_queried_at_once(self):
+        db: FakeDatabase = three_collections(threading.Barrier(3))
+        results: dict = query_many(db, [
+            NamedQuery("bronx", "restaurants", {"borough": "Bronx"}),
+            NamedQuery("inspections", "inspections"),
+            NamedQuery("first_two", "neighborhoods", opts=QueryOptions(limit=2)),
+        ], workers=3)
+        self.assertEqual(list(results), ["bronx", "inspections", "first_two"])
+        self.assertEqual(results["bronx"], [{"_id": 1, "borough": "Bronx"}])
+        self.assertEqual(results["inspections"], [{"_id": 10, "grade": "A"}])
+        self.assertEqual([doc["_id"] for doc in results["first_two"]], [20, 21])
+
+    def test_failures_are_collected_without_fail_fast(self):
+        db: FakeDatabase = three_collections()
+        db["broken"] = FailingCollection("broken")
+        db["also_broken"] = FailingCollection("also_broken")
+        with self.assertRaises(QueryManyError) as caught:
+            query_many(db, [NamedQuery("a", "broken"), NamedQuery("b", "restaurants"), NamedQuery("c", "also_broken")],
+                       workers=1, fail_fast=False)
+        self.assertEqual(sorted(caught.exception.errors), ["a", "c"])
+
+    def test_fail_fast_stops_at_the_first_failure(self):
+        db: FakeDatabase = three_collections()
+        db["broken"] = FailingCollection("broken")
+        with self.assertRaises(QueryManyError) as caught:
+            # with one worker the failure comes first, and the queries still queued are cancelled
+            query_many(db, [NamedQuery("a", "broken"), NamedQuery("b", "restaurants"), NamedQuery("c", "inspections")],
+                       workers=1)
+        self.assertEqual(list(caught.exception.errors), ["a"])
+
+    def test_names_must_be_unique(self):
+        with self.assertRaises(ValueError):
+            query_many(three_collections(), [NamedQuery("a", "restaurants"), NamedQuery("a", "inspections")])
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,71 @@
+import json
+import threading
+import unittest
+import urllib.request
+from unittest import mock
+
+from config import Config
+from server import DashboardHandler, DashboardServer, ShutdownTimeoutError
+from tests.fakes import FakeDatabase
+
+
+class SlowDatabase(FakeDatabase):
+    """
+    A database whose list_collection_names blocks until released, standing in for a slow query.
+    """
+
+    def __init__(self) -> None:
+        super().__init__()
+        self.started: threading.Event = threading.Event()
+        self.release: threading.Event = threading.Event()
+
+    def list_collection_names(self) -> list:
+        self.started.set()
+        self.release.wait(timeout=10)
+        return ["restaurants"]
+
+
+class GracefulShutdownTest(unittest.TestCase):
+
+    def setUp(self):
+        # keep the access log out of the test output
+        patcher = mock.patch.object(DashboardHandler, "log_message")
+        patcher.start()
+        self.addCleanup(patcher.stop)
+        self.db: SlowDatabase = SlowDatabase()
+        cfg: Config = Config()
+        self.httpd: DashboardServer = DashboardServer(("127.0.0.1", 0), cfg, {cfg.database: self.db})
+        self.addCleanup(self.httpd.server_close)
+        self.addCleanup(self.db.release.set)
+        threading.Thread(target=self.httpd.serve_forever, daemon=True).start()
+
+        self.response: dict = {}
+        url: str = f"http://127.0.0.1:{self.httpd.server_address[1]}/collections"
+
+        def request() -> None:
+            with urllib.request.urlopen(url, timeout=10) as r:
+                self.response["status"] = r.status
+                self.response["body"] = json.loads(r.read())
+
+        self.client: threading.Thread = threading.Thread(target=request, daemon=True)
+        self.client.start()
+        self.assertTrue(self.db.started.wait(timeout=10), "the request never reached the handler")
+        # what start_server's interrupt does: the accept loop ends, then running requests are waited for
+        self.httpd.shutdown()
+
+    def test_running_request_completes_during_shutdown(self):
+        threading.Timer(0.2, self.db.release.set).start()
+        self.httpd.shutdown_gracefully(grace=10)
+        self.client.join(timeout=10)
+        self.assertEqual(self.response, {"status": 200, "body": ["restaurants"]})
+
+    def test_request_outlasting_the_grace_period_is_reported(self):
+        with self.assertRaises(ShutdownTimeoutError):
+            self.httpd.shutdown_gracefully(grace=0.1)
+        # let the request finish while the access log is still patched out
+        self.db.release.set()
+        self.client.join(timeout=10)
+
+
+if __name__ == "__main__":
+    unittest.main()
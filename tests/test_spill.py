@@ -0,0 +1,62 @@
+import os
+import tempfile
+import unittest
+
+from spill import SpillBuffer, SpillConfig
+
+
+def docs(count: int) -> list:
+    return [{"_id": i, "name": f"restaurant {i}"} for i in range(count)]
+
+
+class SpillBufferTest(unittest.TestCase):
+
+    def setUp(self):
+        directory = tempfile.TemporaryDirectory()
+        self.addCleanup(directory.cleanup)
+        self.temp_dir: str = directory.name
+
+    def test_large_budget_keeps_everything_in_memory(self):
+        with SpillBuffer(SpillConfig(temp_dir=self.temp_dir)) as buffer:
+            buffer.extend(docs(100))
+            self.assertEqual(buffer.spilled, 0)
+            self.assertEqual(list(buffer), docs(100))
+
+    def test_tiny_budget_spills_and_keeps_the_order(self):
+        # room for a couple of documents, so the rest have to go to disk
+        with SpillBuffer(SpillConfig(max_memory_bytes=100, temp_dir=self.temp_dir)) as buffer:
+            buffer.extend(docs(50))
+            self.assertGreater(buffer.spilled, 0)
+            self.assertLess(buffer.spilled, 50)
+            self.assertEqual(len(buffer), 50)
+            self.assertEqual(list(buffer), docs(50))
+
+    def test_every_iteration_starts_from_the_beginning(self):
+        with SpillBuffer(SpillConfig(max_memory_bytes=1, temp_dir=self.temp_dir)) as buffer:
+            buffer.extend(docs(10))
+            self.assertEqual(buffer.spilled, 10)
+            self.assertEqual(list(buffer), list(buffer))
+            buffer.append({"_id": 10, "name": "restaurant 10"})
+            self.assertEqual(list(buffer), docs(11))
+
+    def test_close_drops_the_documents(self):
+        buffer = SpillBuffer(SpillConfig(max_memory_bytes=1, temp_dir=self.temp_dir))
+        buffer.extend(docs(10))
+        spill_file = buffer._file
+        buffer.close()
+        self.assertTrue(spill_file.closed)
+        self.assertEqual(len(buffer), 0)
+        self.assertEqual(list(buffer), [])
+
+    def test_leaving_on_an_error_deletes_the_spill_file(self):
+        with self.assertRaises(RuntimeError):
+            with SpillBuffer(SpillConfig(max_memory_bytes=1, temp_dir=self.temp_dir)) as buffer:
+                buffer.extend(docs(10))
+                spill_file = buffer._file
+                raise RuntimeError("query failed")
+        self.assertTrue(spill_file.closed)
+        self.assertEqual(os.listdir(self.temp_dir), [])
+
+
+if __name__ == "__main__":
+    unittest.main()